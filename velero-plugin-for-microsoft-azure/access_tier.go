@@ -0,0 +1,120 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+)
+
+const (
+	accessTierConfigKey          = "accessTier"
+	accessTierOverridesConfigKey = "accessTierOverrides"
+	// velero adds the prefix of the BSL to the object store config
+	prefixConfigKey = "prefix"
+)
+
+var supportedAccessTiers = []azblobblob.AccessTier{
+	azblobblob.AccessTierHot,
+	azblobblob.AccessTierCool,
+	azblobblob.AccessTierCold,
+	azblobblob.AccessTierArchive,
+}
+
+// ArchivedObjectError is returned when reading an object in the Archive access tier,
+// which has to be rehydrated to an online tier before it can be read.
+type ArchivedObjectError struct {
+	Key string
+	// the archive status of the object, set once its rehydration has started
+	RehydrationStatus string
+}
+
+func (e *ArchivedObjectError) Error() string {
+	if e.RehydrationStatus != "" {
+		return fmt.Sprintf("object %s is in the Archive access tier and is being rehydrated (%s), it can be read once the rehydration completes", e.Key, e.RehydrationStatus)
+	}
+	return fmt.Sprintf("object %s is in the Archive access tier, rehydration to an online tier is required before it can be read", e.Key)
+}
+
+// accessTierSelector picks the access tier of an uploaded object from its key. The overrides
+// are matched against the key relative to the prefix of the BSL, the longest match wins.
+type accessTierSelector struct {
+	prefix      string
+	defaultTier *azblobblob.AccessTier
+	overrides   map[string]azblobblob.AccessTier
+}
+
+func newAccessTierSelector(config map[string]string) (*accessTierSelector, error) {
+	selector := &accessTierSelector{
+		overrides: map[string]azblobblob.AccessTier{},
+	}
+	if prefix := strings.Trim(config[prefixConfigKey], "/"); prefix != "" {
+		selector.prefix = prefix + "/"
+	}
+
+	if val := config[accessTierConfigKey]; val != "" {
+		tier, err := parseAccessTier(val)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse value %q for config key %q", val, accessTierConfigKey)
+		}
+		selector.defaultTier = &tier
+	}
+
+	overrides, err := util.ConvertTagsToMap(config[accessTierOverridesConfigKey])
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse value %q for config key %q (the valid format is \"prefix1=tier1,prefix2=tier2\")",
+			config[accessTierOverridesConfigKey], accessTierOverridesConfigKey)
+	}
+	for prefix, val := range overrides {
+		tier, err := parseAccessTier(val)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse the tier of prefix %q for config key %q", prefix, accessTierOverridesConfigKey)
+		}
+		selector.overrides[prefix] = tier
+	}
+
+	return selector, nil
+}
+
+func parseAccessTier(val string) (azblobblob.AccessTier, error) {
+	for _, tier := range supportedAccessTiers {
+		if strings.EqualFold(val, string(tier)) {
+			return tier, nil
+		}
+	}
+	return "", errors.Errorf("unsupported access tier %q (expected one of %v)", val, supportedAccessTiers)
+}
+
+// tierFor returns the access tier for the object, or nil to use the default tier of the storage account
+func (s *accessTierSelector) tierFor(key string) *azblobblob.AccessTier {
+	if s == nil {
+		return nil
+	}
+
+	key = strings.TrimPrefix(key, s.prefix)
+	tier, matched := s.defaultTier, ""
+	for prefix, override := range s.overrides {
+		if strings.HasPrefix(key, prefix) && len(prefix) > len(matched) {
+			tier, matched = &override, prefix
+		}
+	}
+	return tier
+}
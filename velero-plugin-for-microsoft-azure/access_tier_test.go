@@ -0,0 +1,97 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessTierSelector(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        map[string]string
+		expected      map[string]*azblobblob.AccessTier
+		expectedError bool
+	}{
+		{
+			name:   "no tier configured",
+			config: map[string]string{},
+			expected: map[string]*azblobblob.AccessTier{
+				"backups/b1/b1.tar.gz": nil,
+			},
+		},
+		{
+			name: "default tier only",
+			config: map[string]string{
+				accessTierConfigKey: "cool",
+			},
+			expected: map[string]*azblobblob.AccessTier{
+				"backups/b1/b1.tar.gz": tierPtr(azblobblob.AccessTierCool),
+			},
+		},
+		{
+			name: "overrides relative to the BSL prefix, longest match wins",
+			config: map[string]string{
+				prefixConfigKey:              "/cluster-1/",
+				accessTierConfigKey:          "Hot",
+				accessTierOverridesConfigKey: "backups/=Cool,backups/b1/=Cold",
+			},
+			expected: map[string]*azblobblob.AccessTier{
+				"cluster-1/backups/b2/b2.tar.gz":  tierPtr(azblobblob.AccessTierCool),
+				"cluster-1/backups/b1/b1.tar.gz":  tierPtr(azblobblob.AccessTierCold),
+				"cluster-1/restores/r1/r1.tar.gz": tierPtr(azblobblob.AccessTierHot),
+			},
+		},
+		{
+			name: "invalid tier",
+			config: map[string]string{
+				accessTierConfigKey: "Frozen",
+			},
+			expectedError: true,
+		},
+		{
+			name: "invalid override",
+			config: map[string]string{
+				accessTierOverridesConfigKey: "backups/",
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			selector, err := newAccessTierSelector(tc.config)
+			if tc.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			for key, expected := range tc.expected {
+				assert.Equal(t, expected, selector.tierFor(key), key)
+			}
+		})
+	}
+}
+
+func tierPtr(tier azblobblob.AccessTier) *azblobblob.AccessTier {
+	return &tier
+}
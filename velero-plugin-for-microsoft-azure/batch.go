@@ -0,0 +1,235 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	azcontainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// the maximum number of subrequests in a batch request
+// ref. https://learn.microsoft.com/en-us/rest/api/storageservices/blob-batch#request-body
+const maxBatchSize = 256
+
+// how many of the failed objects are listed in the message of a BatchDeleteError
+const maxReportedBatchErrors = 10
+
+// BatchDeleteError is returned when some of the objects of a bulk deletion couldn't be
+// deleted. The other objects were deleted.
+type BatchDeleteError struct {
+	// the errors of the objects that couldn't be deleted, by key
+	Errors map[string]error
+}
+
+func (e *BatchDeleteError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for key := range e.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var messages []string
+	for _, key := range keys[:min(len(keys), maxReportedBatchErrors)] {
+		messages = append(messages, fmt.Sprintf("%s: %v", key, e.Errors[key]))
+	}
+	if len(keys) > maxReportedBatchErrors {
+		messages = append(messages, fmt.Sprintf("and %d more", len(keys)-maxReportedBatchErrors))
+	}
+	return fmt.Sprintf("%d objects couldn't be deleted: %s", len(keys), strings.Join(messages, "; "))
+}
+
+// DeleteObjects deletes the objects with batch requests of up to 256 objects, instead of a
+// request per object. Objects that don't exist are considered deleted. It's only used by the
+// delete command: Velero calls DeleteObject for an object at a time, waiting for each deletion
+// before the next, so its deletions can't be batched.
+func (o *ObjectStore) DeleteObjects(bucket string, keys []string) (err error) {
+	ctx, end := startObjectStoreOperation("DeleteObjects", attribute.String("bucket", bucket), attribute.Int("objects", len(keys)))
+	defer end(&err)
+	return o.deleteObjects(ctx, bucket, keys)
+}
+
+func (o *ObjectStore) deleteObjects(ctx context.Context, bucket string, keys []string) error {
+	container := o.containerGetter.getContainer(ctx, bucket)
+	failed := map[string]error{}
+
+	for start := 0; start < len(keys); start += maxBatchSize {
+		batch := keys[start:min(start+maxBatchSize, len(keys))]
+		o.log.Debugf("Deleting a batch of %d objects", len(batch))
+		errs, err := container.DeleteBlobs(batch)
+		if err != nil {
+			// the other batches may still succeed
+			err = errors.Wrapf(err, "error deleting a batch of %d objects", len(batch))
+			for _, key := range batch {
+				failed[key] = err
+			}
+			continue
+		}
+
+		var deleted []string
+		for i, key := range batch {
+			if err := errs[i]; err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+				failed[key] = o.checkImmutability(o.blobGetter.getBlob(ctx, bucket, key), key, err)
+				continue
+			}
+			deleted = append(deleted, key)
+		}
+		if o.deleteAllVersions && len(deleted) > 0 {
+			o.deleteBatchVersions(ctx, container, bucket, deleted, failed)
+		}
+	}
+
+	if len(failed) > 0 {
+		return &BatchDeleteError{Errors: failed}
+	}
+	return nil
+}
+
+// blobVersion is a version of a blob, the current one when the version ID is empty
+type blobVersion struct {
+	Name      string
+	VersionID string
+}
+
+// deleteBatchVersions deletes the previous versions of the objects of a batch, with a single
+// listing of the versions under the common prefix of the objects and batch requests. The
+// objects whose versions couldn't be deleted are recorded in failed.
+func (o *ObjectStore) deleteBatchVersions(ctx context.Context, container container, bucket string, keys []string, failed map[string]error) {
+	keys = slices.Sorted(slices.Values(keys))
+	prefix := commonPrefix(keys)
+	params := azcontainer.ListBlobsFlatOptions{
+		Prefix:  &prefix,
+		Include: azcontainer.ListBlobsInclude{Versions: true},
+	}
+
+	var versions []blobVersion
+	pager := container.ListBlobs(&params)
+listing:
+	for pager.More() {
+		page, err := nextPage(ctx, o.requests, pager)
+		if err != nil {
+			err = errors.Wrapf(err, "error listing the versions of the objects under prefix %q", prefix)
+			for _, key := range keys {
+				failed[key] = err
+			}
+			return
+		}
+
+		for _, item := range page.ListBlobsFlatSegmentResponse.Segment.BlobItems {
+			// the listing is ordered by name, the objects after the last key aren't needed
+			if *item.Name > keys[len(keys)-1] {
+				break listing
+			}
+			if item.VersionID != nil {
+				if _, found := slices.BinarySearch(keys, *item.Name); found {
+					versions = append(versions, blobVersion{Name: *item.Name, VersionID: *item.VersionID})
+				}
+			}
+		}
+	}
+
+	for start := 0; start < len(versions); start += maxBatchSize {
+		batch := versions[start:min(start+maxBatchSize, len(versions))]
+		o.log.Debugf("Deleting a batch of %d versions", len(batch))
+		errs, err := container.DeleteVersions(batch)
+		if err != nil {
+			err = errors.Wrapf(err, "error deleting a batch of %d versions", len(batch))
+			for _, version := range batch {
+				failed[version.Name] = err
+			}
+			continue
+		}
+
+		for i, version := range batch {
+			if err := errs[i]; err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+				err = o.checkImmutability(o.blobGetter.getBlob(ctx, bucket, version.Name), version.Name, err)
+				failed[version.Name] = errors.Wrapf(err, "error deleting version %s of object %s", version.VersionID, version.Name)
+			}
+		}
+	}
+}
+
+// commonPrefix returns the longest prefix of the sorted keys
+func commonPrefix(keys []string) string {
+	first, last := keys[0], keys[len(keys)-1]
+	i := 0
+	for i < len(first) && i < len(last) && first[i] == last[i] {
+		i++
+	}
+	return first[:i]
+}
+
+// DeletePrefix deletes all the objects under the prefix
+func (o *ObjectStore) DeletePrefix(bucket, prefix string) (err error) {
+	if prefix == "" {
+		return errors.New("a prefix is required to delete objects")
+	}
+	ctx, end := startObjectStoreOperation("DeletePrefix", attribute.String("bucket", bucket), attribute.String("prefix", prefix))
+	defer end(&err)
+
+	// the objects are deleted as they are listed, a page at a time
+	var (
+		batch   []string
+		deleted int
+		failed  = map[string]error{}
+	)
+	deleteBatch := func() error {
+		err := o.deleteObjects(ctx, bucket, batch)
+		var batchErr *BatchDeleteError
+		if errors.As(err, &batchErr) {
+			maps.Copy(failed, batchErr.Errors)
+			deleted -= len(batchErr.Errors)
+		} else if err != nil {
+			return err
+		}
+		deleted += len(batch)
+		batch = nil
+		return nil
+	}
+
+	it := o.iterateObjects(ctx, bucket, prefix)
+	for it.Next() {
+		batch = append(batch, *it.Item().Name)
+		if len(batch) == maxBatchSize {
+			if err := deleteBatch(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return errors.Wrapf(err, "error listing objects under prefix %q", prefix)
+	}
+	if len(batch) > 0 {
+		if err := deleteBatch(); err != nil {
+			return err
+		}
+	}
+
+	o.log.Infof("Deleted %d objects under prefix %q", deleted, prefix)
+	if len(failed) > 0 {
+		return &BatchDeleteError{Errors: failed}
+	}
+	return nil
+}
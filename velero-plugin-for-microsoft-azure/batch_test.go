@@ -0,0 +1,177 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azcontainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteObjects(t *testing.T) {
+	var keys []string
+	for i := 0; i < 600; i++ {
+		keys = append(keys, fmt.Sprintf("kopia/p%03d", i))
+	}
+
+	container := &fakeContainer{
+		deleteErrors: map[string]error{
+			"kopia/p001": &azcore.ResponseError{StatusCode: 404, ErrorCode: "BlobNotFound"},
+			"kopia/p300": &azcore.ResponseError{StatusCode: 403, ErrorCode: "AuthorizationPermissionMismatch"},
+		},
+	}
+	containerGetter := new(mockContainerGetter)
+	containerGetter.On("getContainer", "b").Return(container)
+	blobGetter := new(mockBlobGetter)
+	blobGetter.On("getBlob", "b", "kopia/p300").Return(new(mockBlob))
+
+	o := &ObjectStore{log: logrus.New(), containerGetter: containerGetter, blobGetter: blobGetter}
+	err := o.DeleteObjects("b", keys)
+
+	// objects that don't exist are considered deleted
+	var batchErr *BatchDeleteError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Len(t, batchErr.Errors, 1)
+	assert.Contains(t, batchErr.Errors, "kopia/p300")
+	assert.Contains(t, err.Error(), "1 objects couldn't be deleted: kopia/p300: ")
+
+	require.Len(t, container.batches, 3)
+	assert.Len(t, container.batches[0], 256)
+	assert.Len(t, container.batches[1], 256)
+	assert.Len(t, container.batches[2], 88)
+}
+
+func TestDeleteObjectsBatchFails(t *testing.T) {
+	var keys []string
+	for i := 0; i < 600; i++ {
+		keys = append(keys, fmt.Sprintf("kopia/p%03d", i))
+	}
+
+	container := &fakeContainer{
+		batchErrors: map[int]error{1: errors.New("connection reset")},
+	}
+	containerGetter := new(mockContainerGetter)
+	containerGetter.On("getContainer", "b").Return(container)
+
+	o := &ObjectStore{log: logrus.New(), containerGetter: containerGetter}
+	err := o.DeleteObjects("b", keys)
+
+	// the batch after the failed one is still deleted
+	var batchErr *BatchDeleteError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Len(t, batchErr.Errors, 256)
+	assert.Contains(t, batchErr.Errors, "kopia/p256")
+	assert.Contains(t, batchErr.Errors, "kopia/p511")
+	assert.ErrorContains(t, batchErr.Errors["kopia/p256"], "error deleting a batch of 256 objects: connection reset")
+	assert.Len(t, container.batches, 3)
+}
+
+func TestDeleteObjectsVersions(t *testing.T) {
+	container := &fakeContainer{
+		items: []*azcontainer.BlobItem{
+			{Name: to.Ptr("kopia/p1"), VersionID: to.Ptr("v1")},
+			{Name: to.Ptr("kopia/p1"), VersionID: to.Ptr("v2")},
+			{Name: to.Ptr("kopia/p10"), VersionID: to.Ptr("v3")},
+			{Name: to.Ptr("kopia/p2"), VersionID: to.Ptr("v4")},
+			{Name: to.Ptr("kopia/p3"), VersionID: to.Ptr("v5")},
+			{Name: to.Ptr("kopia/p4"), VersionID: to.Ptr("v6")},
+		},
+		deleteErrors: map[string]error{
+			"kopia/p2@v4": &azcore.ResponseError{StatusCode: 403, ErrorCode: "AuthorizationPermissionMismatch"},
+			"kopia/p3":    &azcore.ResponseError{StatusCode: 403, ErrorCode: "AuthorizationPermissionMismatch"},
+		},
+	}
+	containerGetter := new(mockContainerGetter)
+	containerGetter.On("getContainer", "b").Return(container)
+	blobGetter := new(mockBlobGetter)
+	blobGetter.On("getBlob", "b", mock.Anything).Return(new(mockBlob))
+
+	o := &ObjectStore{log: logrus.New(), containerGetter: containerGetter, blobGetter: blobGetter, deleteAllVersions: true}
+	err := o.DeleteObjects("b", []string{"kopia/p2", "kopia/p1", "kopia/p3"})
+
+	var batchErr *BatchDeleteError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Len(t, batchErr.Errors, 2)
+	assert.ErrorContains(t, batchErr.Errors["kopia/p2"], "error deleting version v4 of object kopia/p2")
+	assert.Contains(t, batchErr.Errors, "kopia/p3")
+
+	// the versions are listed once, up to the last deleted object, and deleted in a batch
+	assert.Equal(t, 1, container.pages)
+	assert.Equal(t, [][]blobVersion{{
+		{Name: "kopia/p1", VersionID: "v1"},
+		{Name: "kopia/p1", VersionID: "v2"},
+		{Name: "kopia/p2", VersionID: "v4"},
+	}}, container.versions)
+}
+
+func TestBatchDeleteError(t *testing.T) {
+	failed := map[string]error{}
+	for i := 0; i < 12; i++ {
+		failed[fmt.Sprintf("k%02d", i)] = errors.New("bad")
+	}
+
+	err := &BatchDeleteError{Errors: failed}
+	assert.Equal(t, "12 objects couldn't be deleted: k00: bad; k01: bad; k02: bad; k03: bad; k04: bad; k05: bad; k06: bad; k07: bad; k08: bad; k09: bad; and 2 more", err.Error())
+}
+
+func TestBatchDeleteErrors(t *testing.T) {
+	names := []string{"k0", "k1", "k2", "k3", "k4"}
+	forbidden := &azcore.ResponseError{StatusCode: 403, ErrorCode: "AuthorizationPermissionMismatch"}
+
+	// a partial response: k2 has no response and the other items don't match a subrequest
+	errs := batchDeleteErrors(len(names), []*azcontainer.BatchResponseItem{
+		{ContentID: to.Ptr(0)},
+		{ContentID: to.Ptr(1), Error: forbidden},
+		{ContentID: to.Ptr(3)},
+		{ContentID: nil},
+		{ContentID: to.Ptr(-1)},
+		{ContentID: to.Ptr(5)},
+		nil,
+	})
+
+	require.Len(t, errs, 5)
+	assert.NoError(t, errs[0])
+	assert.Equal(t, forbidden, errs[1])
+	assert.EqualError(t, errs[2], "no response for subrequest")
+	assert.NoError(t, errs[3])
+	assert.EqualError(t, errs[4], "no response for subrequest")
+}
+
+func TestDeletePrefix(t *testing.T) {
+	container := &fakeContainer{
+		items: []*azcontainer.BlobItem{
+			{Name: to.Ptr("backups/b1/k1")},
+			{Name: to.Ptr("backups/b1/k2")},
+		},
+	}
+	containerGetter := new(mockContainerGetter)
+	containerGetter.On("getContainer", "b").Return(container)
+
+	o := &ObjectStore{log: logrus.New(), containerGetter: containerGetter}
+	require.NoError(t, o.DeletePrefix("b", "backups/b1/"))
+	assert.Equal(t, [][]string{{"backups/b1/k1", "backups/b1/k2"}}, container.batches)
+
+	assert.Error(t, o.DeletePrefix("b", ""))
+}
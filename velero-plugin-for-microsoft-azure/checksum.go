@@ -0,0 +1,83 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// ChecksumMismatchError is returned when the content downloaded for an object doesn't
+// match the MD5 that was stored along with it when it was uploaded.
+type ChecksumMismatchError struct {
+	Key      string
+	Expected []byte
+	Actual   []byte
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for object %s: expected MD5 %s, got %s", e.Key,
+		base64.StdEncoding.EncodeToString(e.Expected), base64.StdEncoding.EncodeToString(e.Actual))
+}
+
+// verifyingReadCloser hashes the content as it is read and, once the end of the content
+// is reached, compares the hash with the expected one. A mismatch is returned by Read
+// in place of io.EOF and by every subsequent call to Read or Close.
+type verifyingReadCloser struct {
+	key      string
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected []byte
+	err      error
+}
+
+func newVerifyingReadCloser(key string, body io.ReadCloser, expected []byte) *verifyingReadCloser {
+	return &verifyingReadCloser{
+		key:      key,
+		body:     body,
+		hash:     md5.New(),
+		expected: expected,
+	}
+}
+
+func (r *verifyingReadCloser) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.body.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if actual := r.hash.Sum(nil); !bytes.Equal(actual, r.expected) {
+			r.err = &ChecksumMismatchError{Key: r.key, Expected: r.expected, Actual: actual}
+			return n, r.err
+		}
+	}
+	return n, err
+}
+
+func (r *verifyingReadCloser) Close() error {
+	err := r.body.Close()
+	if r.err != nil {
+		return r.err
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyingReadCloser(t *testing.T) {
+	content := []byte("some backup content")
+	sum := md5.Sum(content)
+
+	// matching content
+	r := newVerifyingReadCloser("k", io.NopCloser(bytes.NewReader(content)), sum[:])
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	assert.NoError(t, r.Close())
+
+	// corrupted content
+	r = newVerifyingReadCloser("k", io.NopCloser(bytes.NewReader([]byte("some backup c0ntent"))), sum[:])
+	_, err = io.ReadAll(r)
+	var mismatch *ChecksumMismatchError
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, "k", mismatch.Key)
+	assert.Equal(t, sum[:], mismatch.Expected)
+	assert.ErrorAs(t, r.Close(), &mismatch)
+
+	// closing before the end of the content doesn't report a mismatch
+	r = newVerifyingReadCloser("k", io.NopCloser(bytes.NewReader(content)), []byte("wrong"))
+	_, err = r.Read(make([]byte, 4))
+	require.NoError(t, err)
+	assert.NoError(t, r.Close())
+}
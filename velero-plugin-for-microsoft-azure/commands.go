@@ -0,0 +1,103 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// objectStoreCommand is an operator command run against a backup storage location, e.g.
+// kubectl -n velero exec deploy/velero -- /plugins/velero-plugin-for-microsoft-azure rehydrate \
+// --bucket my-bucket --prefix my-prefix/backups/my-backup/ --config storageAccount=my-sa,resourceGroup=my-rg
+type objectStoreCommand struct {
+	description string
+	run         func(o *ObjectStore, bucket, prefix string) error
+}
+
+var objectStoreCommands = map[string]objectStoreCommand{
+	"rehydrate": {
+		description: "start the rehydration of all the archived objects under the prefix",
+		run: func(o *ObjectStore, bucket, prefix string) error {
+			return o.RehydratePrefix(bucket, prefix)
+		},
+	},
+	"list-deleted": {
+		description: "list the deleted objects under the prefix that can be recovered",
+		run: func(o *ObjectStore, bucket, prefix string) error {
+			deleted, err := o.ListDeleted(bucket, prefix)
+			if err != nil {
+				return err
+			}
+			for _, object := range deleted {
+				if object.VersionID != "" {
+					fmt.Printf("%s (version %s)\n", object.Key, object.VersionID)
+				} else {
+					fmt.Printf("%s (soft-deleted)\n", object.Key)
+				}
+			}
+			return nil
+		},
+	},
+	"undelete": {
+		description: "recover the deleted objects under the prefix",
+		run: func(o *ObjectStore, bucket, prefix string) error {
+			return o.UndeletePrefix(bucket, prefix)
+		},
+	},
+	"delete": {
+		description: "delete all the objects under the prefix with batch requests",
+		run: func(o *ObjectStore, bucket, prefix string) error {
+			return o.DeletePrefix(bucket, prefix)
+		},
+	},
+}
+
+// runCommand runs the operator command named by the first argument. It returns false
+// when the arguments don't name a command, i.e. the plugin is being run by Velero.
+func runCommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	command, ok := objectStoreCommands[args[0]]
+	if !ok {
+		return false, nil
+	}
+
+	flags := pflag.NewFlagSet(args[0], pflag.ContinueOnError)
+	bucket := flags.String("bucket", "", "the blob container of the backup storage location")
+	prefix := flags.String("prefix", "", "the prefix of the objects to run the command against")
+	config := flags.StringToString("config", nil, "the config of the backup storage location, e.g. storageAccount=my-sa,resourceGroup=my-rg")
+	flags.Usage = func() {
+		fmt.Printf("Usage of %s, which will %s:\n%s", args[0], command.description, flags.FlagUsages())
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return true, err
+	}
+	if *bucket == "" {
+		return true, errors.New("--bucket is required")
+	}
+
+	o := newObjectStore(logrus.New())
+	if err := o.Init(*config); err != nil {
+		return true, err
+	}
+	return true, command.run(o, *bucket, *prefix)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	compressionConfigKey = "compression"
+	// the blob metadata recording the codec an object is compressed with
+	compressionMetadataKey = "velerocompression"
+
+	compressionZstd = "zstd"
+	compressionGzip = "gzip"
+)
+
+func getCompression(config map[string]string) (string, error) {
+	val := config[compressionConfigKey]
+	if val == "" {
+		return "", nil
+	}
+
+	for _, codec := range []string{compressionZstd, compressionGzip} {
+		if strings.EqualFold(val, codec) {
+			return codec, nil
+		}
+	}
+	return "", errors.Errorf("unsupported value %q for config key %q (expected %s or %s)", val, compressionConfigKey, compressionZstd, compressionGzip)
+}
+
+// compressObject returns the body compressed with the codec. The body is compressed as it is
+// read by a goroutine, closing the returned reader stops the compression and waits for the
+// goroutine to stop reading the body, so that the body can be closed or reused after it.
+func compressObject(codec string, body io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var w io.WriteCloser
+		switch codec {
+		case compressionZstd:
+			encoder, err := zstd.NewWriter(pw)
+			if err != nil {
+				pw.CloseWithError(errors.Wrap(err, "error creating zstd encoder"))
+				return
+			}
+			w = encoder
+		default:
+			w = gzip.NewWriter(pw)
+		}
+
+		_, err := io.Copy(w, body)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	return &compressingReadCloser{PipeReader: pr, done: done}
+}
+
+type compressingReadCloser struct {
+	*io.PipeReader
+	// closed when the goroutine compressing the body returns
+	done chan struct{}
+}
+
+func (r *compressingReadCloser) Close() error {
+	// the writes of the goroutine fail once the reader is closed, which stops its copy
+	err := r.PipeReader.CloseWithError(errors.New("the compression of the object was stopped"))
+	<-r.done
+	return err
+}
+
+// decompressObject returns the decompressed content of an object compressed by the plugin, or
+// the body as is if the object isn't compressed, whatever the compression configured
+func decompressObject(key string, body io.ReadCloser, metadata map[string]*string) (io.ReadCloser, error) {
+	var (
+		reader io.Reader
+		close  = body.Close
+	)
+	switch codec := getMetadata(metadata, compressionMetadataKey); codec {
+	case "":
+		return body, nil
+	case compressionZstd:
+		decoder, err := zstd.NewReader(body)
+		if err != nil {
+			body.Close()
+			return nil, errors.Wrapf(err, "error decompressing object %s", key)
+		}
+		reader = decoder
+		close = func() error {
+			decoder.Close()
+			return body.Close()
+		}
+	case compressionGzip:
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			body.Close()
+			return nil, errors.Wrapf(err, "error decompressing object %s", key)
+		}
+		reader = gzipReader
+	default:
+		body.Close()
+		return nil, errors.Errorf("object %s is compressed with unsupported codec %s", key, codec)
+	}
+
+	return &decompressingReadCloser{key: key, reader: reader, close: close}, nil
+}
+
+type decompressingReadCloser struct {
+	key    string
+	reader io.Reader
+	close  func() error
+}
+
+func (r *decompressingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		return n, errors.Wrapf(err, "error decompressing object %s", r.key)
+	}
+	return n, err
+}
+
+func (r *decompressingReadCloser) Close() error {
+	return r.close()
+}
@@ -0,0 +1,167 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCompression(t *testing.T) {
+	tests := []struct {
+		val         string
+		expected    string
+		expectError bool
+	}{
+		{val: "", expected: ""},
+		{val: "zstd", expected: compressionZstd},
+		{val: "GZIP", expected: compressionGzip},
+		{val: "lz4", expectError: true},
+	}
+
+	for _, tc := range tests {
+		codec, err := getCompression(map[string]string{compressionConfigKey: tc.val})
+		if tc.expectError {
+			assert.Error(t, err, tc.val)
+			continue
+		}
+		require.NoError(t, err, tc.val)
+		assert.Equal(t, tc.expected, codec)
+	}
+}
+
+func TestCompressObject(t *testing.T) {
+	content := bytes.Repeat([]byte(`{"kind":"Pod","apiVersion":"v1"}`), 1000)
+
+	for _, codec := range []string{compressionZstd, compressionGzip} {
+		t.Run(codec, func(t *testing.T) {
+			compressed, err := io.ReadAll(compressObject(codec, bytes.NewReader(content)))
+			require.NoError(t, err)
+			assert.Less(t, len(compressed), len(content)/10)
+
+			body, err := decompressObject("k", io.NopCloser(bytes.NewReader(compressed)), map[string]*string{"Velerocompression": to.Ptr(codec)})
+			require.NoError(t, err)
+			decompressed, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, content, decompressed)
+			assert.NoError(t, body.Close())
+
+			// corrupted content
+			body, err = decompressObject("k", io.NopCloser(bytes.NewReader(compressed[:len(compressed)/2])), map[string]*string{compressionMetadataKey: to.Ptr(codec)})
+			if err == nil {
+				_, err = io.ReadAll(body)
+			}
+			assert.Error(t, err)
+		})
+	}
+
+	// objects that aren't compressed are read as is
+	body, err := decompressObject("k", io.NopCloser(bytes.NewReader(content)), nil)
+	require.NoError(t, err)
+	read, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, content, read)
+
+	_, err = decompressObject("k", io.NopCloser(bytes.NewReader(content)), map[string]*string{compressionMetadataKey: to.Ptr("lz4")})
+	assert.EqualError(t, err, "object k is compressed with unsupported codec lz4")
+}
+
+func TestPutObjectWithCompression(t *testing.T) {
+	wrapper, err := newLocalKeyWrapper(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+
+	for _, tc := range []struct {
+		name       string
+		keyWrapper keyWrapper
+	}{
+		{name: "compression only"},
+		{name: "compression and encryption", keyWrapper: wrapper},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			blobGetter := new(mockBlobGetter)
+			blob := new(mockBlob)
+			defer blob.AssertExpectations(t)
+			blobGetter.On("getBlob", "b", "k").Return(blob)
+
+			o := &ObjectStore{
+				log:         logrus.New(),
+				blobGetter:  blobGetter,
+				blockSize:   1024,
+				keyWrapper:  tc.keyWrapper,
+				compression: compressionZstd,
+			}
+
+			var staged []byte
+			var metadata map[string]*string
+			blob.On("PutBlock", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				staged = append(staged, args.Get(1).([]byte)...)
+			}).Return(nil)
+			blob.On("PutBlockList", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				metadata = args.Get(1).(*blockblob.CommitBlockListOptions).Metadata
+			}).Return(nil)
+
+			require.NoError(t, o.PutObject("b", "k", bytes.NewReader(content)))
+			assert.Less(t, len(staged), len(content)/10)
+			assert.Equal(t, compressionZstd, getMetadata(metadata, compressionMetadataKey))
+
+			// decompressed whatever the compression configured
+			o.compression = ""
+			blob.On("Get", mock.Anything).Return(io.NopCloser(bytes.NewReader(staged)), metadata, nil)
+			body, err := o.GetObject("b", "k")
+			require.NoError(t, err)
+			downloaded, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, content, downloaded)
+		})
+	}
+}
+
+// closeTrackingReader fails the test if it's read after being closed
+type closeTrackingReader struct {
+	t      *testing.T
+	reader io.Reader
+	closed atomic.Bool
+}
+
+func (r *closeTrackingReader) Read(p []byte) (int, error) {
+	assert.False(r.t, r.closed.Load(), "the body was read after the compression was closed")
+	return r.reader.Read(p)
+}
+
+func TestCompressObjectClose(t *testing.T) {
+	body := &closeTrackingReader{t: t, reader: rand.Reader}
+	compressed := compressObject(compressionGzip, body)
+	_, err := io.ReadFull(compressed, make([]byte, 1024))
+	require.NoError(t, err)
+
+	// the upload failed, the body is closed once the compression is
+	require.NoError(t, compressed.Close())
+	body.closed.Store(true)
+	_, err = compressed.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
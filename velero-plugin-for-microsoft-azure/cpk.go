@@ -0,0 +1,103 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/pkg/errors"
+	"github.com/vmware-tanzu/velero/pkg/util/azure"
+)
+
+// the name of the variable in the credentials file that contains the base64 encoded
+// AES-256 key objects are encrypted with
+const customerProvidedKeyConfigKey = "customerProvidedKeyEnvVar"
+
+// EncryptionKeyMismatchError is returned when an object can't be accessed because it was
+// written with another customer-provided key than the configured one, or without any.
+type EncryptionKeyMismatchError struct {
+	Key    string
+	Reason string
+}
+
+func (e *EncryptionKeyMismatchError) Error() string {
+	return fmt.Sprintf("object %s can't be accessed with the configured customer-provided key: %s", e.Key, e.Reason)
+}
+
+func getCustomerProvidedKey(config map[string]string) (*azblobblob.CPKInfo, error) {
+	name := config[customerProvidedKeyConfigKey]
+	if name == "" {
+		return nil, nil
+	}
+
+	creds, err := azure.LoadCredentials(config)
+	if err != nil {
+		return nil, err
+	}
+	encoded := creds[name]
+	if encoded == "" {
+		return nil, errors.Errorf("no customer-provided key with key %s found in credential", name)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decode the customer-provided key %s (expected a base64 encoded value)", name)
+	}
+	if len(key) != 32 {
+		return nil, errors.Errorf("the customer-provided key %s must be a 256-bit AES key, got %d bits", name, len(key)*8)
+	}
+
+	sum := sha256.Sum256(key)
+	return &azblobblob.CPKInfo{
+		EncryptionKey:       to.Ptr(encoded),
+		EncryptionKeySHA256: to.Ptr(base64.StdEncoding.EncodeToString(sum[:])),
+		EncryptionAlgorithm: to.Ptr(azblobblob.EncryptionAlgorithmTypeAES256),
+	}, nil
+}
+
+// checkEncryptionKey explains an error accessing an object when it is caused by the object
+// being encrypted with another customer-provided key than the configured one. Otherwise the
+// error is returned as is.
+func (o *ObjectStore) checkEncryptionKey(blob blob, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if bloberror.HasCode(err, bloberror.BlobUsesCustomerSpecifiedEncryption) && o.cpkInfo == nil {
+		return &EncryptionKeyMismatchError{Key: key, Reason: fmt.Sprintf("the object is encrypted with a customer-provided key but none is configured, set %s", customerProvidedKeyConfigKey)}
+	}
+	if o.cpkInfo == nil || bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+		return err
+	}
+
+	// the properties of an object can be read without its key, they tell which key the object was written with
+	props, propsErr := blob.GetProperties(nil)
+	if propsErr != nil {
+		return err
+	}
+	switch {
+	case props.EncryptionKeySHA256 == nil:
+		return &EncryptionKeyMismatchError{Key: key, Reason: "the object is not encrypted with a customer-provided key"}
+	case *props.EncryptionKeySHA256 != *o.cpkInfo.EncryptionKeySHA256:
+		return &EncryptionKeyMismatchError{Key: key, Reason: fmt.Sprintf("the object is encrypted with another key (SHA256 %s)", *props.EncryptionKeySHA256)}
+	}
+	return err
+}
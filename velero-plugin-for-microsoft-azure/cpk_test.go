@@ -0,0 +1,151 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCredentialsFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "credentials")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestGetCustomerProvidedKey(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	sum := sha256.Sum256(key)
+
+	credentialsFile := writeCredentialsFile(t, "CPK="+encoded+"\nSHORT_KEY="+base64.StdEncoding.EncodeToString(key[:16])+"\nNOT_BASE64=???\n")
+
+	// not configured
+	cpkInfo, err := getCustomerProvidedKey(map[string]string{})
+	require.NoError(t, err)
+	assert.Nil(t, cpkInfo)
+
+	// valid key
+	cpkInfo, err = getCustomerProvidedKey(map[string]string{credentialsFileConfigKey: credentialsFile, customerProvidedKeyConfigKey: "CPK"})
+	require.NoError(t, err)
+	assert.Equal(t, encoded, *cpkInfo.EncryptionKey)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), *cpkInfo.EncryptionKeySHA256)
+	assert.Equal(t, azblobblob.EncryptionAlgorithmTypeAES256, *cpkInfo.EncryptionAlgorithm)
+
+	// invalid keys
+	for _, name := range []string{"MISSING", "SHORT_KEY", "NOT_BASE64"} {
+		_, err = getCustomerProvidedKey(map[string]string{credentialsFileConfigKey: credentialsFile, customerProvidedKeyConfigKey: name})
+		assert.Error(t, err, name)
+	}
+}
+
+func TestCheckEncryptionKey(t *testing.T) {
+	cpkInfo := &azblobblob.CPKInfo{EncryptionKey: to.Ptr("key"), EncryptionKeySHA256: to.Ptr("sha")}
+	conflict := &azcore.ResponseError{StatusCode: 409, ErrorCode: "SomeConflict"}
+
+	tests := []struct {
+		name           string
+		cpkInfo        *azblobblob.CPKInfo
+		err            error
+		storedKeySHA   *string
+		expectMismatch bool
+	}{
+		{
+			name:           "object uses a key but none is configured",
+			err:            &azcore.ResponseError{StatusCode: 409, ErrorCode: "BlobUsesCustomerSpecifiedEncryption"},
+			expectMismatch: true,
+		},
+		{
+			name:           "object encrypted with another key",
+			cpkInfo:        cpkInfo,
+			err:            conflict,
+			storedKeySHA:   to.Ptr("other"),
+			expectMismatch: true,
+		},
+		{
+			name:           "object not encrypted with a customer-provided key",
+			cpkInfo:        cpkInfo,
+			err:            conflict,
+			expectMismatch: true,
+		},
+		{
+			name:         "object encrypted with the configured key",
+			cpkInfo:      cpkInfo,
+			err:          conflict,
+			storedKeySHA: to.Ptr("sha"),
+		},
+		{
+			name: "no key involved",
+			err:  errors.New("bad"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			blob := new(mockBlob)
+			blob.On("GetProperties", (*azblobblob.GetPropertiesOptions)(nil)).Return(azblobblob.GetPropertiesResponse{EncryptionKeySHA256: tc.storedKeySHA}, nil).Maybe()
+
+			o := &ObjectStore{log: logrus.New(), cpkInfo: tc.cpkInfo}
+			err := o.checkEncryptionKey(blob, "k", tc.err)
+
+			var mismatch *EncryptionKeyMismatchError
+			if tc.expectMismatch {
+				require.ErrorAs(t, err, &mismatch)
+				assert.Equal(t, "k", mismatch.Key)
+				return
+			}
+			assert.Equal(t, tc.err, err)
+		})
+	}
+}
+
+func TestPutObjectWithCustomerProvidedKey(t *testing.T) {
+	cpkInfo := &azblobblob.CPKInfo{EncryptionKey: to.Ptr("key"), EncryptionKeySHA256: to.Ptr("sha")}
+	body := []byte("0123456789")
+
+	blobGetter := new(mockBlobGetter)
+	blob := new(mockBlob)
+	defer blob.AssertExpectations(t)
+	blobGetter.On("getBlob", "b", "k").Return(blob)
+
+	o := &ObjectStore{
+		log:        logrus.New(),
+		blobGetter: blobGetter,
+		blockSize:  len(body),
+		cpkInfo:    cpkInfo,
+	}
+
+	blob.On("GetUncommittedBlocks").Return([]string(nil), nil)
+	blob.On("PutBlock", getBlockID(0, body), body, &blockblob.StageBlockOptions{CPKInfo: cpkInfo}).Return(nil)
+	blob.On("PutBlockList", mock.Anything, &blockblob.CommitBlockListOptions{CPKInfo: cpkInfo}).Return(nil)
+
+	require.NoError(t, o.PutObject("b", "k", bytes.NewReader(body)))
+}
@@ -0,0 +1,217 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v4"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/azuredisk-csi-driver/pkg/util"
+)
+
+const (
+	vslConfigKeyDiskIOPSReadWrite   = "diskIOPSReadWrite"
+	vslConfigKeyDiskMBpsReadWrite   = "diskMBpsReadWrite"
+	vslConfigKeyDiskTier            = "diskTier"
+	vslConfigKeyDiskBurstingEnabled = "diskBurstingEnabled"
+
+	// the tags recording the performance settings of the disk of a snapshot, to restore them with it
+	diskIOPSReadWriteTag   = "velero.io-disk-iops-read-write"
+	diskMBpsReadWriteTag   = "velero.io-disk-mbps-read-write"
+	diskTierTag            = "velero.io-disk-tier"
+	diskBurstingEnabledTag = "velero.io-disk-bursting-enabled"
+)
+
+// diskPerformance is the performance settings of a disk, nil when they aren't set
+type diskPerformance struct {
+	iopsReadWrite   *int64
+	mbpsReadWrite   *int64
+	tier            *string
+	burstingEnabled *bool
+}
+
+// hasProvisionedPerformance returns whether the IOPS and throughput of the disks of the SKU are
+// provisioned, i.e. Premium SSD v2 and Ultra disks
+func hasProvisionedPerformance(sku armcompute.DiskStorageAccountTypes) bool {
+	return sku == armcompute.DiskStorageAccountTypesPremiumV2LRS || sku == armcompute.DiskStorageAccountTypesUltraSSDLRS
+}
+
+// hasPerformanceTier returns whether the disks of the SKU have a performance tier and can burst,
+// i.e. Premium SSD disks
+func hasPerformanceTier(sku armcompute.DiskStorageAccountTypes) bool {
+	return sku == armcompute.DiskStorageAccountTypesPremiumLRS || sku == armcompute.DiskStorageAccountTypesPremiumZRS
+}
+
+// getDiskPerformance returns the performance settings of the disk that apply to its SKU
+func getDiskPerformance(disk *armcompute.Disk) diskPerformance {
+	var perf diskPerformance
+	if disk.SKU == nil || disk.SKU.Name == nil || disk.Properties == nil {
+		return perf
+	}
+	if hasProvisionedPerformance(*disk.SKU.Name) {
+		perf.iopsReadWrite = disk.Properties.DiskIOPSReadWrite
+		perf.mbpsReadWrite = disk.Properties.DiskMBpsReadWrite
+	}
+	if hasPerformanceTier(*disk.SKU.Name) {
+		perf.tier = disk.Properties.Tier
+		perf.burstingEnabled = disk.Properties.BurstingEnabled
+	}
+	return perf
+}
+
+// tags returns the tags recording the performance settings
+func (p diskPerformance) tags() map[string]*string {
+	tags := make(map[string]*string)
+	if p.iopsReadWrite != nil {
+		tags[diskIOPSReadWriteTag] = stringPtr(strconv.FormatInt(*p.iopsReadWrite, 10))
+	}
+	if p.mbpsReadWrite != nil {
+		tags[diskMBpsReadWriteTag] = stringPtr(strconv.FormatInt(*p.mbpsReadWrite, 10))
+	}
+	if p.tier != nil {
+		tags[diskTierTag] = stringPtr(*p.tier)
+	}
+	if p.burstingEnabled != nil {
+		tags[diskBurstingEnabledTag] = stringPtr(strconv.FormatBool(*p.burstingEnabled))
+	}
+	return tags
+}
+
+// getDiskPerformanceFromTags returns the performance settings recorded in the tags of a snapshot,
+// ignoring the values that can't be parsed
+func getDiskPerformanceFromTags(tags map[string]*string) diskPerformance {
+	var perf diskPerformance
+	if v, err := strconv.ParseInt(deref(tags[diskIOPSReadWriteTag]), 10, 64); err == nil {
+		perf.iopsReadWrite = &v
+	}
+	if v, err := strconv.ParseInt(deref(tags[diskMBpsReadWriteTag]), 10, 64); err == nil {
+		perf.mbpsReadWrite = &v
+	}
+	if v := tags[diskTierTag]; v != nil && *v != "" {
+		perf.tier = stringPtr(*v)
+	}
+	if v, err := strconv.ParseBool(deref(tags[diskBurstingEnabledTag])); err == nil {
+		perf.burstingEnabled = &v
+	}
+	return perf
+}
+
+// withoutDiskPerformanceTags returns the tags of a snapshot without the tags recording the
+// performance settings of its disk, for the disks restored from it
+func withoutDiskPerformanceTags(tags map[string]*string) map[string]*string {
+	if tags == nil {
+		return nil
+	}
+	diskTags := make(map[string]*string, len(tags))
+	for k, v := range tags {
+		switch k {
+		case diskIOPSReadWriteTag, diskMBpsReadWriteTag, diskTierTag, diskBurstingEnabledTag:
+		default:
+			diskTags[k] = v
+		}
+	}
+	return diskTags
+}
+
+// override returns the performance settings overridden by the settings set in overrides
+func (p diskPerformance) override(overrides diskPerformance) diskPerformance {
+	if overrides.iopsReadWrite != nil {
+		p.iopsReadWrite = overrides.iopsReadWrite
+	}
+	if overrides.mbpsReadWrite != nil {
+		p.mbpsReadWrite = overrides.mbpsReadWrite
+	}
+	if overrides.tier != nil {
+		p.tier = overrides.tier
+	}
+	if overrides.burstingEnabled != nil {
+		p.burstingEnabled = overrides.burstingEnabled
+	}
+	return p
+}
+
+// apply sets the performance settings that apply to the SKU of the disk
+func (p diskPerformance) apply(disk *armcompute.Disk) {
+	sku := *disk.SKU.Name
+	if hasProvisionedPerformance(sku) {
+		disk.Properties.DiskIOPSReadWrite = p.iopsReadWrite
+		disk.Properties.DiskMBpsReadWrite = p.mbpsReadWrite
+	}
+	if hasPerformanceTier(sku) {
+		disk.Properties.Tier = p.tier
+		disk.Properties.BurstingEnabled = p.burstingEnabled
+	}
+}
+
+// parseDiskPerformanceOverrides parses the performance settings of the restored disks by SKU, each
+// setting formatted as "sku1=value1,sku2=value2", and checks they apply to their SKU
+func parseDiskPerformanceOverrides(config map[string]string) (map[armcompute.DiskStorageAccountTypes]diskPerformance, error) {
+	overrides := make(map[armcompute.DiskStorageAccountTypes]diskPerformance)
+	for _, key := range []string{vslConfigKeyDiskIOPSReadWrite, vslConfigKeyDiskMBpsReadWrite, vslConfigKeyDiskTier, vslConfigKeyDiskBurstingEnabled} {
+		val := config[key]
+		if val == "" {
+			continue
+		}
+		values, err := util.ConvertTagsToMap(val)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse value %q for config key %q (the valid format is \"sku1=value1,sku2=value2\")", val, key)
+		}
+
+		for name, v := range values {
+			sku := armcompute.DiskStorageAccountTypes(name)
+			if !slices.Contains(armcompute.PossibleDiskStorageAccountTypesValues(), sku) {
+				return nil, errors.Errorf("unable to parse value %q for config key %q (%q isn't a disk SKU)", val, key, name)
+			}
+			perf := overrides[sku]
+			switch key {
+			case vslConfigKeyDiskIOPSReadWrite, vslConfigKeyDiskMBpsReadWrite:
+				if !hasProvisionedPerformance(sku) {
+					return nil, errors.Errorf("config key %q only applies to the SKUs %s and %s, not %s", key, armcompute.DiskStorageAccountTypesPremiumV2LRS, armcompute.DiskStorageAccountTypesUltraSSDLRS, sku)
+				}
+				n, err := strconv.ParseInt(v, 10, 64)
+				if err != nil || n <= 0 {
+					return nil, errors.Errorf("unable to parse value %q for config key %q (expected positive integers)", val, key)
+				}
+				if key == vslConfigKeyDiskIOPSReadWrite {
+					perf.iopsReadWrite = &n
+				} else {
+					perf.mbpsReadWrite = &n
+				}
+			case vslConfigKeyDiskTier, vslConfigKeyDiskBurstingEnabled:
+				if !hasPerformanceTier(sku) {
+					return nil, errors.Errorf("config key %q only applies to the SKUs %s and %s, not %s", key, armcompute.DiskStorageAccountTypesPremiumLRS, armcompute.DiskStorageAccountTypesPremiumZRS, sku)
+				}
+				if key == vslConfigKeyDiskTier {
+					if v == "" {
+						return nil, errors.Errorf("unable to parse value %q for config key %q (expected performance tiers, e.g. P30)", val, key)
+					}
+					perf.tier = stringPtr(v)
+					break
+				}
+				enabled, err := strconv.ParseBool(v)
+				if err != nil {
+					return nil, errors.Wrapf(err, "unable to parse value %q for config key %q (expected boolean values)", val, key)
+				}
+				perf.burstingEnabled = &enabled
+			}
+			overrides[sku] = perf
+		}
+	}
+	return overrides, nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDiskPerformanceOverrides(t *testing.T) {
+	overrides, err := parseDiskPerformanceOverrides(map[string]string{
+		vslConfigKeyDiskIOPSReadWrite:   "PremiumV2_LRS=5000, UltraSSD_LRS=10000",
+		vslConfigKeyDiskMBpsReadWrite:   "PremiumV2_LRS=200",
+		vslConfigKeyDiskTier:            "Premium_LRS=P30",
+		vslConfigKeyDiskBurstingEnabled: "Premium_LRS=true",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[armcompute.DiskStorageAccountTypes]diskPerformance{
+		armcompute.DiskStorageAccountTypesPremiumV2LRS: {iopsReadWrite: to.Ptr[int64](5000), mbpsReadWrite: to.Ptr[int64](200)},
+		armcompute.DiskStorageAccountTypesUltraSSDLRS:  {iopsReadWrite: to.Ptr[int64](10000)},
+		armcompute.DiskStorageAccountTypesPremiumLRS:   {tier: to.Ptr("P30"), burstingEnabled: to.Ptr(true)},
+	}, overrides)
+
+	for key, val := range map[string]string{
+		vslConfigKeyDiskIOPSReadWrite:   "Premium_LRS=5000",
+		vslConfigKeyDiskMBpsReadWrite:   "PremiumV2_LRS=fast",
+		vslConfigKeyDiskTier:            "PremiumV3_LRS=P30",
+		vslConfigKeyDiskBurstingEnabled: "Premium_LRS",
+	} {
+		_, err := parseDiskPerformanceOverrides(map[string]string{key: val})
+		assert.Error(t, err, key)
+	}
+}
+
+func TestDiskPerformanceTags(t *testing.T) {
+	perf := getDiskPerformance(&armcompute.Disk{
+		SKU: &armcompute.DiskSKU{Name: to.Ptr(armcompute.DiskStorageAccountTypesPremiumV2LRS)},
+		Properties: &armcompute.DiskProperties{
+			DiskIOPSReadWrite: to.Ptr[int64](4000),
+			DiskMBpsReadWrite: to.Ptr[int64](150),
+			// Premium SSD v2 disks have no performance tier
+			Tier: to.Ptr("P10"),
+		},
+	})
+	assert.Equal(t, diskPerformance{iopsReadWrite: to.Ptr[int64](4000), mbpsReadWrite: to.Ptr[int64](150)}, perf)
+
+	tags := perf.tags()
+	assert.Equal(t, map[string]*string{diskIOPSReadWriteTag: to.Ptr("4000"), diskMBpsReadWriteTag: to.Ptr("150")}, tags)
+	assert.Equal(t, perf, getDiskPerformanceFromTags(tags))
+
+	tags["key"] = to.Ptr("value")
+	assert.Equal(t, map[string]*string{"key": to.Ptr("value")}, withoutDiskPerformanceTags(tags))
+}
+
+func TestRestoreDiskPerformance(t *testing.T) {
+	compute := newFakeCompute(t)
+	var disk armcompute.Disk
+	b := newFakeARMSnapshotter(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/disks/disk-1"):
+			fmt.Fprint(w, `{"name": "disk-1", "location": "westeurope", "sku": {"name": "PremiumV2_LRS"},`+
+				` "properties": {"diskIOPSReadWrite": 4000, "diskMBpsReadWrite": 150}}`)
+		case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/disks/"):
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&disk))
+			fmt.Fprint(w, `{"location": "westeurope", "properties": {"provisioningState": "Succeeded"}}`)
+		default:
+			compute.ServeHTTP(w, r)
+		}
+	})
+	b.diskPerformanceOverrides = map[armcompute.DiskStorageAccountTypes]diskPerformance{
+		armcompute.DiskStorageAccountTypesPremiumV2LRS: {mbpsReadWrite: to.Ptr[int64](300)},
+	}
+
+	volumeType, iops, err := b.GetVolumeInfo("disk-1", "westeurope-1")
+	require.NoError(t, err)
+	assert.Equal(t, "PremiumV2_LRS", volumeType)
+	assert.Equal(t, to.Ptr[int64](4000), iops)
+
+	snapshotID, err := b.CreateSnapshot("disk-1", "westeurope-1", map[string]string{"velero.io/backup": "backup-1"})
+	require.NoError(t, err)
+	snap, err := parseFullSnapshotName(snapshotID)
+	require.NoError(t, err)
+	assert.Equal(t, "150", *compute.snapshots[snap.name].Tags[diskMBpsReadWriteTag])
+
+	_, err = b.CreateVolumeFromSnapshot(snapshotID, volumeType, "westeurope-1", to.Ptr[int64](5000))
+	require.NoError(t, err)
+	// the IOPS recorded by Velero and the overridden throughput are applied, the tags recording them aren't
+	assert.Equal(t, int64(5000), *disk.Properties.DiskIOPSReadWrite)
+	assert.Equal(t, int64(300), *disk.Properties.DiskMBpsReadWrite)
+	assert.Nil(t, disk.Properties.Tier)
+	assert.Equal(t, map[string]*string{"velero.io-backup": to.Ptr("backup-1")}, disk.Tags)
+}
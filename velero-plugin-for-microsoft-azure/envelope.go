@@ -0,0 +1,399 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/pkg/errors"
+)
+
+const (
+	// the path of a file that contains the base64 encoded AES-256 key the data keys of objects
+	// are wrapped with when they are encrypted client-side
+	clientSideEncryptionKeyFileConfigKey = "clientSideEncryptionKeyFile"
+	// the paths of the files that contain the keys used before the key was rotated, separated
+	// by commas, which are only used to unwrap the data keys of the objects encrypted with them
+	clientSideEncryptionPreviousKeyFilesConfigKey = "clientSideEncryptionPreviousKeyFiles"
+)
+
+const (
+	// the blob metadata describing how an object is encrypted client-side
+	encryptionAlgorithmMetadataKey = "veleroencryptionalgorithm"
+	wrappedKeyMetadataKey          = "velerowrappedkey"
+	keyWrapAlgorithmMetadataKey    = "velerokeywrapalgorithm"
+	keyIDMetadataKey               = "velerokeyid"
+
+	// objects are encrypted as a sequence of segments with AES-256-GCM, so that they can be
+	// encrypted and decrypted as a stream
+	encryptionAlgorithmAES256GCM = "AES256-GCM-64K"
+	encryptionSegmentSize        = 64 * 1024
+	keyWrapAlgorithmA256KW       = "A256KW"
+)
+
+// keyWrapper wraps the data keys objects are encrypted with. It mirrors the wrapKey and
+// unwrapKey operations of Key Vault, so that a key held in a Key Vault or a managed HSM can
+// be used in place of a local key.
+type keyWrapper interface {
+	// KeyID identifies the key-encryption key, it is stored along with the wrapped data key
+	KeyID() string
+	Algorithm() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	// UnwrapKey unwraps a data key wrapped with the key identified by keyID, which may be a
+	// key used before the key was rotated. It returns errUnknownKey when the key isn't known.
+	UnwrapKey(keyID string, wrappedKey []byte) ([]byte, error)
+}
+
+var errUnknownKey = errors.New("unknown key-encryption key")
+
+// localKeyWrapper wraps data keys with a key read from a local file, using the AES key
+// wrap algorithm of RFC 3394.
+type localKeyWrapper struct {
+	id    string
+	block cipher.Block
+	// the keys used before the key was rotated, by ID
+	previous map[string]cipher.Block
+}
+
+func getKeyWrapper(config map[string]string) (keyWrapper, error) {
+	path := config[clientSideEncryptionKeyFileConfigKey]
+	if path == "" {
+		return nil, nil
+	}
+
+	wrapper, err := readLocalKeyWrapper(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if val := config[clientSideEncryptionPreviousKeyFilesConfigKey]; val != "" {
+		for _, path := range strings.Split(val, ",") {
+			previous, err := readLocalKeyWrapper(strings.TrimSpace(path))
+			if err != nil {
+				return nil, err
+			}
+			wrapper.previous[previous.id] = previous.block
+		}
+	}
+	return wrapper, nil
+}
+
+func readLocalKeyWrapper(path string) (*localKeyWrapper, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read the client-side encryption key file %s", path)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decode the client-side encryption key in %s (expected a base64 encoded value)", path)
+	}
+
+	return newLocalKeyWrapper(key)
+}
+
+func newLocalKeyWrapper(key []byte) (*localKeyWrapper, error) {
+	if len(key) != 32 {
+		return nil, errors.Errorf("the client-side encryption key must be a 256-bit AES key, got %d bits", len(key)*8)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// the ID tells keys apart without revealing them
+	sum := sha256.Sum256(key)
+	return &localKeyWrapper{id: "local:" + hex.EncodeToString(sum[:8]), block: block, previous: map[string]cipher.Block{}}, nil
+}
+
+func (w *localKeyWrapper) KeyID() string {
+	return w.id
+}
+
+func (w *localKeyWrapper) Algorithm() string {
+	return keyWrapAlgorithmA256KW
+}
+
+var keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+func (w *localKeyWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	return wrapKey(w.block, dataKey)
+}
+
+func (w *localKeyWrapper) UnwrapKey(keyID string, wrappedKey []byte) ([]byte, error) {
+	block := w.previous[keyID]
+	if keyID == w.id {
+		block = w.block
+	}
+	if block == nil {
+		return nil, errUnknownKey
+	}
+	return unwrapKey(block, wrappedKey)
+}
+
+// wrapKey wraps the data key with the AES key wrap algorithm of RFC 3394
+func wrapKey(kek cipher.Block, dataKey []byte) ([]byte, error) {
+	if len(dataKey)%8 != 0 || len(dataKey) < 16 {
+		return nil, errors.Errorf("unable to wrap a key of %d bytes", len(dataKey))
+	}
+
+	n := len(dataKey) / 8
+	wrapped := make([]byte, 8+len(dataKey))
+	copy(wrapped, keyWrapIV)
+	copy(wrapped[8:], dataKey)
+
+	block := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(block, wrapped[:8])
+			copy(block[8:], wrapped[8*i:8*i+8])
+			kek.Encrypt(block, block)
+
+			binary.BigEndian.PutUint64(wrapped[:8], binary.BigEndian.Uint64(block[:8])^uint64(n*j+i))
+			copy(wrapped[8*i:8*i+8], block[8:])
+		}
+	}
+	return wrapped, nil
+}
+
+// unwrapKey unwraps a data key wrapped with the AES key wrap algorithm of RFC 3394, checking
+// its integrity
+func unwrapKey(kek cipher.Block, wrappedKey []byte) ([]byte, error) {
+	if len(wrappedKey)%8 != 0 || len(wrappedKey) < 24 {
+		return nil, errors.Errorf("unable to unwrap a key of %d bytes", len(wrappedKey))
+	}
+
+	n := len(wrappedKey)/8 - 1
+	unwrapped := make([]byte, len(wrappedKey))
+	copy(unwrapped, wrappedKey)
+
+	block := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			binary.BigEndian.PutUint64(block[:8], binary.BigEndian.Uint64(unwrapped[:8])^uint64(n*j+i))
+			copy(block[8:], unwrapped[8*i:8*i+8])
+			kek.Decrypt(block, block)
+
+			copy(unwrapped[:8], block[:8])
+			copy(unwrapped[8*i:8*i+8], block[8:])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(unwrapped[:8], keyWrapIV) != 1 {
+		return nil, errors.New("the integrity check of the unwrapped key failed")
+	}
+	return unwrapped[8:], nil
+}
+
+// encryptObject encrypts the body with a new data key, and returns the metadata the object
+// must be stored with to be decrypted: the data key wrapped with the key-encryption key and
+// the algorithms in use.
+func encryptObject(wrapper keyWrapper, body io.Reader) (io.Reader, map[string]*string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, errors.Wrap(err, "error generating a data key")
+	}
+	wrappedKey, err := wrapper.WrapKey(dataKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error wrapping the data key")
+	}
+	aead, err := newSegmentCipher(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metadata := map[string]*string{
+		encryptionAlgorithmMetadataKey: to.Ptr(encryptionAlgorithmAES256GCM),
+		wrappedKeyMetadataKey:          to.Ptr(base64.StdEncoding.EncodeToString(wrappedKey)),
+		keyWrapAlgorithmMetadataKey:    to.Ptr(wrapper.Algorithm()),
+		keyIDMetadataKey:               to.Ptr(wrapper.KeyID()),
+	}
+	return newSegmentReader(body, encryptionSegmentSize, func(counter uint64, segment []byte, final bool) ([]byte, error) {
+		return aead.Seal(nil, segmentNonce(counter), segment, segmentAdditionalData(final)), nil
+	}), metadata, nil
+}
+
+// decryptObject returns the decrypted content of an object encrypted client-side, or the
+// body as is if the object isn't encrypted
+func decryptObject(wrapper keyWrapper, key string, body io.ReadCloser, metadata map[string]*string) (io.ReadCloser, error) {
+	algorithm := getMetadata(metadata, encryptionAlgorithmMetadataKey)
+	if algorithm == "" {
+		return body, nil
+	}
+
+	dataKey, err := unwrapDataKey(wrapper, key, algorithm, metadata)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	aead, err := newSegmentCipher(dataKey)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	reader := newSegmentReader(body, encryptionSegmentSize+aead.Overhead(), func(counter uint64, segment []byte, final bool) ([]byte, error) {
+		plaintext, err := aead.Open(nil, segmentNonce(counter), segment, segmentAdditionalData(final))
+		if err != nil {
+			return nil, errors.Errorf("unable to decrypt object %s, its content was modified or truncated", key)
+		}
+		return plaintext, nil
+	})
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, body}, nil
+}
+
+func unwrapDataKey(wrapper keyWrapper, key, algorithm string, metadata map[string]*string) ([]byte, error) {
+	if algorithm != encryptionAlgorithmAES256GCM {
+		return nil, errors.Errorf("object %s is encrypted client-side with unsupported algorithm %s", key, algorithm)
+	}
+	if wrapper == nil {
+		return nil, errors.Errorf("object %s is encrypted client-side but no key is configured, set %s", key, clientSideEncryptionKeyFileConfigKey)
+	}
+	if keyWrapAlgorithm := getMetadata(metadata, keyWrapAlgorithmMetadataKey); keyWrapAlgorithm != wrapper.Algorithm() {
+		return nil, errors.Errorf("the data key of object %s is wrapped with unsupported algorithm %s", key, keyWrapAlgorithm)
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(getMetadata(metadata, wrappedKeyMetadataKey))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decode the wrapped data key of object %s", key)
+	}
+	keyID := getMetadata(metadata, keyIDMetadataKey)
+	dataKey, err := wrapper.UnwrapKey(keyID, wrappedKey)
+	if errors.Is(err, errUnknownKey) {
+		return nil, errors.Errorf("object %s is encrypted client-side with key %s, the configured key is %s and it isn't one of the previous keys (set by %s)",
+			key, keyID, wrapper.KeyID(), clientSideEncryptionPreviousKeyFilesConfigKey)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error unwrapping the data key of object %s", key)
+	}
+	return dataKey, nil
+}
+
+// getMetadata returns the value of a metadata key, the case of the keys returned by Azure
+// isn't preserved
+func getMetadata(metadata map[string]*string, name string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, name) && v != nil {
+			return *v
+		}
+	}
+	return ""
+}
+
+func newSegmentCipher(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, errors.WithStack(err)
+}
+
+// every object has its own data key, so the nonce only has to be unique within the object.
+// Deriving it from the position of the segment prevents segments from being reordered.
+func segmentNonce(counter uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+// the last segment is authenticated as such, so that a truncated object can't be decrypted
+func segmentAdditionalData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// segmentReader splits the source into segments of a fixed size, the last one being shorter,
+// and returns the result of transforming each of them in order.
+type segmentReader struct {
+	src       io.Reader
+	transform func(counter uint64, segment []byte, final bool) ([]byte, error)
+	// one byte more than a segment is read, to tell whether the segment is the last one
+	buf      []byte
+	buffered int
+	counter  uint64
+	out      *bytes.Reader
+	done     bool
+	err      error
+}
+
+func newSegmentReader(src io.Reader, segmentSize int, transform func(counter uint64, segment []byte, final bool) ([]byte, error)) *segmentReader {
+	return &segmentReader{
+		src:       src,
+		transform: transform,
+		buf:       make([]byte, segmentSize+1),
+		out:       bytes.NewReader(nil),
+	}
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.nextSegment()
+	}
+
+	return r.out.Read(p)
+}
+
+func (r *segmentReader) nextSegment() error {
+	segmentSize := len(r.buf) - 1
+	n, err := io.ReadFull(r.src, r.buf[r.buffered:])
+	n += r.buffered
+	final := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !final {
+		return err
+	}
+	if !final {
+		n = segmentSize
+	}
+
+	out, err := r.transform(r.counter, r.buf[:n], final)
+	if err != nil {
+		return err
+	}
+	r.out = bytes.NewReader(out)
+	r.counter++
+
+	if final {
+		r.done = true
+	} else {
+		r.buf[0] = r.buf[segmentSize]
+		r.buffered = 1
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAESKeyWrap(t *testing.T) {
+	// test vectors of section 4 of RFC 3394
+	for name, tc := range map[string]struct {
+		kek, dataKey, wrapped string
+	}{
+		"4.1 128-bit data with a 128-bit KEK": {
+			kek:     "000102030405060708090A0B0C0D0E0F",
+			dataKey: "00112233445566778899AABBCCDDEEFF",
+			wrapped: "1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5",
+		},
+		"4.2 128-bit data with a 192-bit KEK": {
+			kek:     "000102030405060708090A0B0C0D0E0F1011121314151617",
+			dataKey: "00112233445566778899AABBCCDDEEFF",
+			wrapped: "96778B25AE6CA435F92B5B97C050AED2468AB8A17AD84E5D",
+		},
+		"4.3 128-bit data with a 256-bit KEK": {
+			kek:     "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			dataKey: "00112233445566778899AABBCCDDEEFF",
+			wrapped: "64E8C3F9CE0F5BA263E9777905818A2A93C8191E7D6E8AE7",
+		},
+		"4.4 192-bit data with a 192-bit KEK": {
+			kek:     "000102030405060708090A0B0C0D0E0F1011121314151617",
+			dataKey: "00112233445566778899AABBCCDDEEFF0001020304050607",
+			wrapped: "031D33264E15D33268F24EC260743EDCE1C6C7DDEE725A936BA814915C6762D2",
+		},
+		"4.5 192-bit data with a 256-bit KEK": {
+			kek:     "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			dataKey: "00112233445566778899AABBCCDDEEFF0001020304050607",
+			wrapped: "A8F9BC1612C68B3FF6E6F4FBE30E71E4769C8B80A32CB8958CD5D17D6B254DA1",
+		},
+		"4.6 256-bit data with a 256-bit KEK": {
+			kek:     "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			dataKey: "00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+			wrapped: "28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			kek, _ := hex.DecodeString(tc.kek)
+			dataKey, _ := hex.DecodeString(tc.dataKey)
+			expected, _ := hex.DecodeString(tc.wrapped)
+			block, err := aes.NewCipher(kek)
+			require.NoError(t, err)
+
+			wrapped, err := wrapKey(block, dataKey)
+			require.NoError(t, err)
+			assert.Equal(t, expected, wrapped)
+
+			unwrapped, err := unwrapKey(block, wrapped)
+			require.NoError(t, err)
+			assert.Equal(t, dataKey, unwrapped)
+
+			// a modified wrapped key fails the integrity check
+			wrapped[len(wrapped)-1] ^= 1
+			_, err = unwrapKey(block, wrapped)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLocalKeyWrapper(t *testing.T) {
+	wrapper, err := newLocalKeyWrapper(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	dataKey := bytes.Repeat([]byte{3}, 32)
+
+	wrapped, err := wrapper.WrapKey(dataKey)
+	require.NoError(t, err)
+	unwrapped, err := wrapper.UnwrapKey(wrapper.KeyID(), wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	// after a rotation, the data keys wrapped with the previous key are still unwrapped
+	rotated, err := newLocalKeyWrapper(bytes.Repeat([]byte{2}, 32))
+	require.NoError(t, err)
+	assert.NotEqual(t, wrapper.KeyID(), rotated.KeyID())
+	_, err = rotated.UnwrapKey(wrapper.KeyID(), wrapped)
+	assert.ErrorIs(t, err, errUnknownKey)
+	rotated.previous[wrapper.KeyID()] = wrapper.block
+	unwrapped, err = rotated.UnwrapKey(wrapper.KeyID(), wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	// another key fails the integrity check
+	_, err = rotated.UnwrapKey(rotated.KeyID(), wrapped)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errUnknownKey)
+
+	_, err = newLocalKeyWrapper(dataKey[:16])
+	assert.Error(t, err)
+}
+
+func TestGetKeyWrapper(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid")
+	require.NoError(t, os.WriteFile(valid, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))+"\n"), 0600))
+	invalid := filepath.Join(dir, "invalid")
+	require.NoError(t, os.WriteFile(invalid, []byte("???"), 0600))
+
+	wrapper, err := getKeyWrapper(map[string]string{})
+	require.NoError(t, err)
+	assert.Nil(t, wrapper)
+
+	wrapper, err = getKeyWrapper(map[string]string{clientSideEncryptionKeyFileConfigKey: valid})
+	require.NoError(t, err)
+	assert.NotNil(t, wrapper)
+
+	previous := filepath.Join(dir, "previous")
+	require.NoError(t, os.WriteFile(previous, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))), 0600))
+	wrapper, err = getKeyWrapper(map[string]string{
+		clientSideEncryptionKeyFileConfigKey:          valid,
+		clientSideEncryptionPreviousKeyFilesConfigKey: previous + ", " + previous,
+	})
+	require.NoError(t, err)
+	assert.Len(t, wrapper.(*localKeyWrapper).previous, 1)
+
+	_, err = getKeyWrapper(map[string]string{clientSideEncryptionKeyFileConfigKey: valid, clientSideEncryptionPreviousKeyFilesConfigKey: invalid})
+	assert.Error(t, err)
+
+	for _, path := range []string{invalid, filepath.Join(dir, "missing")} {
+		_, err = getKeyWrapper(map[string]string{clientSideEncryptionKeyFileConfigKey: path})
+		assert.Error(t, err, path)
+	}
+}
+
+func TestEncryptObject(t *testing.T) {
+	wrapper, err := newLocalKeyWrapper(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+
+	for _, size := range []int{0, 1, encryptionSegmentSize - 1, encryptionSegmentSize, encryptionSegmentSize + 1, 3*encryptionSegmentSize + 10} {
+		content := make([]byte, size)
+		rand.Read(content)
+
+		encrypted, metadata, err := encryptObject(wrapper, bytes.NewReader(content))
+		require.NoError(t, err)
+		ciphertext, err := io.ReadAll(encrypted)
+		require.NoError(t, err)
+
+		// every object gets its own data key, so the same content never encrypts the same way twice
+		encrypted, _, err = encryptObject(wrapper, bytes.NewReader(content))
+		require.NoError(t, err)
+		other, err := io.ReadAll(encrypted)
+		require.NoError(t, err)
+		assert.NotEqual(t, ciphertext, other, size)
+
+		body, err := decryptObject(wrapper, "k", io.NopCloser(bytes.NewReader(ciphertext)), metadata)
+		require.NoError(t, err)
+		decrypted, err := io.ReadAll(body)
+		require.NoError(t, err, size)
+		assert.Equal(t, content, decrypted, size)
+
+		// a truncated object is detected, even when truncated at the end of a segment
+		for _, truncated := range [][]byte{ciphertext[:len(ciphertext)-1], ciphertext[:len(ciphertext)-len(ciphertext)%(encryptionSegmentSize+16)]} {
+			if len(truncated) == len(ciphertext) {
+				continue
+			}
+			body, err = decryptObject(wrapper, "k", io.NopCloser(bytes.NewReader(truncated)), metadata)
+			require.NoError(t, err)
+			_, err = io.ReadAll(body)
+			assert.Error(t, err, size)
+		}
+	}
+}
+
+func TestDecryptObject(t *testing.T) {
+	wrapper, err := newLocalKeyWrapper(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	other, err := newLocalKeyWrapper(bytes.Repeat([]byte{2}, 32))
+	require.NoError(t, err)
+
+	encrypted, metadata, err := encryptObject(wrapper, bytes.NewReader([]byte("content")))
+	require.NoError(t, err)
+	ciphertext, err := io.ReadAll(encrypted)
+	require.NoError(t, err)
+
+	// objects that aren't encrypted are read as is
+	body, err := decryptObject(wrapper, "k", io.NopCloser(bytes.NewReader([]byte("plain"))), nil)
+	require.NoError(t, err)
+	content, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(content))
+
+	// no key configured
+	_, err = decryptObject(nil, "k", io.NopCloser(bytes.NewReader(ciphertext)), metadata)
+	assert.EqualError(t, err, "object k is encrypted client-side but no key is configured, set clientSideEncryptionKeyFile")
+
+	// another key configured
+	_, err = decryptObject(other, "k", io.NopCloser(bytes.NewReader(ciphertext)), metadata)
+	assert.ErrorContains(t, err, "the configured key is "+other.KeyID())
+
+	// the key was rotated
+	other.previous[wrapper.KeyID()] = wrapper.block
+	body, err = decryptObject(other, "k", io.NopCloser(bytes.NewReader(ciphertext)), metadata)
+	require.NoError(t, err)
+	content, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	// Azure doesn't preserve the case of metadata keys
+	canonical := map[string]*string{}
+	for k, v := range metadata {
+		canonical[string(k[0]-'a'+'A')+k[1:]] = v
+	}
+	body, err = decryptObject(wrapper, "k", io.NopCloser(bytes.NewReader(ciphertext)), canonical)
+	require.NoError(t, err)
+	content, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestPutObjectWithClientSideEncryption(t *testing.T) {
+	wrapper, err := newLocalKeyWrapper(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	content := []byte("0123456789")
+
+	blobGetter := new(mockBlobGetter)
+	blob := new(mockBlob)
+	defer blob.AssertExpectations(t)
+	blobGetter.On("getBlob", "b", "k").Return(blob)
+
+	o := &ObjectStore{
+		log:        logrus.New(),
+		blobGetter: blobGetter,
+		blockSize:  1024,
+		keyWrapper: wrapper,
+	}
+
+	var staged []byte
+	var metadata map[string]*string
+	blob.On("PutBlock", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		staged = append(staged, args.Get(1).([]byte)...)
+	}).Return(nil)
+	blob.On("PutBlockList", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		metadata = args.Get(1).(*blockblob.CommitBlockListOptions).Metadata
+	}).Return(nil)
+
+	require.NoError(t, o.PutObject("b", "k", bytes.NewReader(content)))
+	assert.NotContains(t, string(staged), string(content))
+	assert.Equal(t, wrapper.KeyID(), getMetadata(metadata, keyIDMetadataKey))
+
+	blob.On("Get", mock.Anything).Return(io.NopCloser(bytes.NewReader(staged)), metadata, nil)
+	body, err := o.GetObject("b", "k")
+	require.NoError(t, err)
+	downloaded, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/pkg/errors"
+)
+
+const (
+	immutabilityPeriodConfigKey     = "immutabilityPeriodInDays"
+	immutabilityPolicyModeConfigKey = "immutabilityPolicyMode"
+	legalHoldConfigKey              = "legalHold"
+)
+
+// the error code of a write to a blob under a legal hold, which bloberror doesn't define
+const blobImmutableDueToLegalHold bloberror.Code = "BlobImmutableDueToLegalHold"
+
+// ImmutableObjectError is returned when an object can't be deleted or overwritten because
+// of its immutability policy or legal hold.
+type ImmutableObjectError struct {
+	Key string
+	// the expiry of the immutability policy of the object, if any
+	Until     *time.Time
+	LegalHold bool
+}
+
+func (e *ImmutableObjectError) Error() string {
+	var reasons []string
+	if e.Until != nil {
+		reasons = append(reasons, fmt.Sprintf("is immutable until %s", e.Until.UTC().Format(time.RFC3339)))
+	}
+	if e.LegalHold {
+		reasons = append(reasons, "is under a legal hold")
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "is immutable")
+	}
+	return fmt.Sprintf("object %s %s", e.Key, strings.Join(reasons, " and "))
+}
+
+// immutability is the time-based retention policy and the legal hold committed objects are
+// protected with. The storage account or the container must support version-level immutability.
+type immutability struct {
+	period    time.Duration
+	mode      azblobblob.ImmutabilityPolicySetting
+	legalHold bool
+}
+
+func getImmutability(config map[string]string) (immutability, error) {
+	result := immutability{mode: azblobblob.ImmutabilityPolicySettingUnlocked}
+
+	days, err := getIntConfig(config, immutabilityPeriodConfigKey, 0, 1, 146000)
+	if err != nil {
+		return result, err
+	}
+	result.period = time.Duration(days) * 24 * time.Hour
+
+	if val := config[immutabilityPolicyModeConfigKey]; val != "" {
+		if result.period == 0 {
+			return result, errors.Errorf("config key %q requires config key %q", immutabilityPolicyModeConfigKey, immutabilityPeriodConfigKey)
+		}
+		switch {
+		case strings.EqualFold(val, string(azblobblob.ImmutabilityPolicySettingUnlocked)):
+			result.mode = azblobblob.ImmutabilityPolicySettingUnlocked
+		case strings.EqualFold(val, string(azblobblob.ImmutabilityPolicySettingLocked)):
+			result.mode = azblobblob.ImmutabilityPolicySettingLocked
+		default:
+			return result, errors.Errorf("unsupported value %q for config key %q (expected %s or %s)", val, immutabilityPolicyModeConfigKey,
+				azblobblob.ImmutabilityPolicySettingUnlocked, azblobblob.ImmutabilityPolicySettingLocked)
+		}
+	}
+
+	if val := config[legalHoldConfigKey]; val != "" {
+		if result.legalHold, err = strconv.ParseBool(val); err != nil {
+			return result, errors.Wrapf(err, "unable to parse value %q for config key %q (expected a boolean value)", val, legalHoldConfigKey)
+		}
+	}
+
+	return result, nil
+}
+
+// apply sets the immutability policy and the legal hold the object is committed with
+func (i immutability) apply(options *blockblob.CommitBlockListOptions) {
+	if i.period > 0 {
+		options.ImmutabilityPolicyExpiryTime = to.Ptr(time.Now().Add(i.period))
+		options.ImmutabilityPolicyMode = to.Ptr(i.mode)
+	}
+	if i.legalHold {
+		options.LegalHold = to.Ptr(true)
+	}
+}
+
+// checkImmutability explains an error writing or deleting an object when it is caused by the
+// immutability policy or the legal hold of the object. Otherwise the error is returned as is.
+func (o *ObjectStore) checkImmutability(blob blob, key string, err error) error {
+	if !bloberror.HasCode(err, bloberror.BlobImmutableDueToPolicy, blobImmutableDueToLegalHold) {
+		return err
+	}
+
+	immutableErr := &ImmutableObjectError{Key: key, LegalHold: bloberror.HasCode(err, blobImmutableDueToLegalHold)}
+	props, propsErr := blob.GetProperties(&azblobblob.GetPropertiesOptions{CPKInfo: o.cpkInfo})
+	if propsErr != nil {
+		o.log.WithError(propsErr).Warnf("Error getting the immutability policy of %s", key)
+		return immutableErr
+	}
+	if props.ImmutabilityPolicyExpiresOn != nil && props.ImmutabilityPolicyExpiresOn.After(time.Now()) {
+		immutableErr.Until = props.ImmutabilityPolicyExpiresOn
+	}
+	if props.LegalHold != nil && *props.LegalHold {
+		immutableErr.LegalHold = true
+	}
+	return immutableErr
+}
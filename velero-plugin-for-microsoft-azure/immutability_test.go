@@ -0,0 +1,153 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetImmutability(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      map[string]string
+		expected    immutability
+		expectError bool
+	}{
+		{
+			name:     "not configured",
+			config:   map[string]string{},
+			expected: immutability{mode: azblobblob.ImmutabilityPolicySettingUnlocked},
+		},
+		{
+			name:     "locked policy and legal hold",
+			config:   map[string]string{immutabilityPeriodConfigKey: "30", immutabilityPolicyModeConfigKey: "locked", legalHoldConfigKey: "true"},
+			expected: immutability{period: 30 * 24 * time.Hour, mode: azblobblob.ImmutabilityPolicySettingLocked, legalHold: true},
+		},
+		{
+			name:        "mode without period",
+			config:      map[string]string{immutabilityPolicyModeConfigKey: "Locked"},
+			expectError: true,
+		},
+		{
+			name:        "invalid mode",
+			config:      map[string]string{immutabilityPeriodConfigKey: "30", immutabilityPolicyModeConfigKey: "Mutable"},
+			expectError: true,
+		},
+		{
+			name:        "invalid period",
+			config:      map[string]string{immutabilityPeriodConfigKey: "0"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := getImmutability(tc.config)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestPutObjectWithImmutability(t *testing.T) {
+	body := []byte("0123456789")
+
+	blobGetter := new(mockBlobGetter)
+	blob := new(mockBlob)
+	defer blob.AssertExpectations(t)
+	blobGetter.On("getBlob", "b", "k").Return(blob)
+
+	o := &ObjectStore{
+		log:          logrus.New(),
+		blobGetter:   blobGetter,
+		blockSize:    len(body),
+		immutability: immutability{period: 24 * time.Hour, mode: azblobblob.ImmutabilityPolicySettingUnlocked, legalHold: true},
+	}
+
+	blob.On("GetUncommittedBlocks").Return([]string(nil), nil)
+	blob.On("PutBlock", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	blob.On("PutBlockList", mock.Anything, mock.MatchedBy(func(options *blockblob.CommitBlockListOptions) bool {
+		return options.ImmutabilityPolicyExpiryTime.After(time.Now().Add(23*time.Hour)) &&
+			*options.ImmutabilityPolicyMode == azblobblob.ImmutabilityPolicySettingUnlocked &&
+			*options.LegalHold
+	})).Return(nil)
+
+	require.NoError(t, o.PutObject("b", "k", bytes.NewReader(body)))
+}
+
+func TestDeleteImmutableObject(t *testing.T) {
+	until := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		err           error
+		props         azblobblob.GetPropertiesResponse
+		expectedError string
+	}{
+		{
+			name:          "immutability policy",
+			err:           &azcore.ResponseError{StatusCode: 409, ErrorCode: "BlobImmutableDueToPolicy"},
+			props:         azblobblob.GetPropertiesResponse{ImmutabilityPolicyExpiresOn: &until},
+			expectedError: "object k is immutable until 2100-01-01T00:00:00Z",
+		},
+		{
+			name:          "legal hold",
+			err:           &azcore.ResponseError{StatusCode: 409, ErrorCode: "BlobImmutableDueToLegalHold"},
+			props:         azblobblob.GetPropertiesResponse{ImmutabilityPolicyExpiresOn: &until, LegalHold: to.Ptr(true)},
+			expectedError: "object k is immutable until 2100-01-01T00:00:00Z and is under a legal hold",
+		},
+		{
+			name:          "other error",
+			err:           errors.New("bad"),
+			expectedError: "bad",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			blobGetter := new(mockBlobGetter)
+			blob := new(mockBlob)
+			blobGetter.On("getBlob", "b", "k").Return(blob)
+			blob.On("Delete", (*azblob.DeleteBlobOptions)(nil)).Return(tc.err)
+			blob.On("GetProperties", mock.Anything).Return(tc.props, nil).Maybe()
+
+			o := &ObjectStore{log: logrus.New(), blobGetter: blobGetter}
+			err := o.DeleteObject("b", "k")
+			assert.EqualError(t, err, tc.expectedError)
+
+			var immutableErr *ImmutableObjectError
+			assert.Equal(t, tc.props.ImmutabilityPolicyExpiresOn != nil, errors.As(err, &immutableErr))
+		})
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"math"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azcontainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/pkg/errors"
+)
+
+const (
+	listPageSizeConfigKey         = "listPageSize"
+	maxListResultsConfigKey       = "maxListResults"
+	listWarningThresholdConfigKey = "listWarningThreshold"
+	// Azure returns at most 5000 items per page
+	// ref. https://learn.microsoft.com/en-us/rest/api/storageservices/list-blobs#uri-parameters
+	maxListPageSize             = 5000
+	defaultListWarningThreshold = 1000000
+)
+
+// listing bounds the listings of ListObjects and ListCommonPrefixes, which return all the
+// names at once
+type listing struct {
+	// the number of items per page, 0 to use the default of Azure
+	pageSize int32
+	// the number of names a listing fails past, 0 for no limit
+	maxResults int64
+	// the number of names a listing logs a warning past, 0 to never warn
+	warningThreshold int64
+}
+
+func getListing(config map[string]string) (listing, error) {
+	var result listing
+	pageSize, err := getIntConfig(config, listPageSizeConfigKey, 0, 1, maxListPageSize)
+	if err != nil {
+		return result, err
+	}
+	result.pageSize = int32(pageSize)
+	if result.maxResults, err = getIntConfig(config, maxListResultsConfigKey, 0, 1, math.MaxInt64); err != nil {
+		return result, err
+	}
+	if result.warningThreshold, err = getIntConfig(config, listWarningThresholdConfigKey, defaultListWarningThreshold, 0, math.MaxInt64); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (l listing) maxResultsPtr() *int32 {
+	if l.pageSize == 0 {
+		return nil
+	}
+	return to.Ptr(l.pageSize)
+}
+
+// listIterator iterates over a listing one page at a time, so that only the current page
+// is held in memory however large the listing is.
+type listIterator[T any] struct {
+	more     func() bool
+	nextPage func() ([]T, error)
+	page     []T
+	index    int
+	err      error
+}
+
+// Next advances to the next item, it returns false once the listing is over or failed
+func (it *listIterator[T]) Next() bool {
+	for it.index+1 >= len(it.page) {
+		if it.err != nil || !it.more() {
+			return false
+		}
+		it.page, it.err = it.nextPage()
+		it.index = -1
+		if it.err != nil {
+			return false
+		}
+	}
+	it.index++
+	return true
+}
+
+func (it *listIterator[T]) Item() T {
+	return it.page[it.index]
+}
+
+func (it *listIterator[T]) Err() error {
+	return it.err
+}
+
+// iterateObjects lists the objects under the prefix
+func (o *ObjectStore) iterateObjects(ctx context.Context, bucket, prefix string) *listIterator[*azcontainer.BlobItem] {
+	container := o.containerGetter.getContainer(ctx, bucket)
+	pager := container.ListBlobs(&azcontainer.ListBlobsFlatOptions{
+		Prefix:     &prefix,
+		MaxResults: o.listing.maxResultsPtr(),
+	})
+
+	return &listIterator[*azcontainer.BlobItem]{
+		more: pager.More,
+		nextPage: func() ([]*azcontainer.BlobItem, error) {
+			page, err := nextPage(ctx, o.requests, pager)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return page.Segment.BlobItems, nil
+		},
+	}
+}
+
+// iteratePrefixes lists the common prefixes under the prefix
+func (o *ObjectStore) iteratePrefixes(ctx context.Context, bucket, prefix, delimiter string) *listIterator[*azcontainer.BlobPrefix] {
+	container := o.containerGetter.getContainer(ctx, bucket)
+	pager := container.ListBlobsHierarchy(delimiter, &azcontainer.ListBlobsHierarchyOptions{
+		Prefix:     &prefix,
+		MaxResults: o.listing.maxResultsPtr(),
+	})
+
+	return &listIterator[*azcontainer.BlobPrefix]{
+		more: pager.More,
+		nextPage: func() ([]*azcontainer.BlobPrefix, error) {
+			page, err := nextPage(ctx, o.requests, pager)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return page.Segment.BlobPrefixes, nil
+		},
+	}
+}
+
+// collectNames returns the names of all the items of the listing, within the limits of the listing config
+func collectNames[T any](o *ObjectStore, it *listIterator[T], name func(T) string, prefix string) ([]string, error) {
+	var names []string
+	for it.Next() {
+		if o.listing.maxResults > 0 && int64(len(names)) >= o.listing.maxResults {
+			return nil, errors.Errorf("listing of prefix %q returned more than %d items (the limit set by config key %q)", prefix, o.listing.maxResults, maxListResultsConfigKey)
+		}
+		names = append(names, name(it.Item()))
+		if int64(len(names)) == o.listing.warningThreshold {
+			o.log.Warnf("Listing of prefix %q returned more than %d items so far, which uses a lot of memory", prefix, o.listing.warningThreshold)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azcontainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetListing(t *testing.T) {
+	l, err := getListing(map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, listing{warningThreshold: defaultListWarningThreshold}, l)
+	assert.Nil(t, l.maxResultsPtr())
+
+	l, err = getListing(map[string]string{listPageSizeConfigKey: "100", maxListResultsConfigKey: "1000", listWarningThresholdConfigKey: "0"})
+	require.NoError(t, err)
+	assert.Equal(t, listing{pageSize: 100, maxResults: 1000}, l)
+	assert.Equal(t, int32(100), *l.maxResultsPtr())
+
+	_, err = getListing(map[string]string{listPageSizeConfigKey: "5001"})
+	assert.Error(t, err)
+}
+
+func newListingObjectStore(items []*azcontainer.BlobItem, prefixes []string, l listing) (*ObjectStore, *fakeContainer) {
+	container := &fakeContainer{items: items, prefixes: prefixes}
+	containerGetter := new(mockContainerGetter)
+	containerGetter.On("getContainer", "b").Return(container)
+	return &ObjectStore{log: logrus.New(), containerGetter: containerGetter, listing: l}, container
+}
+
+func TestListObjects(t *testing.T) {
+	var items []*azcontainer.BlobItem
+	var names []string
+	for i := 0; i < 10; i++ {
+		names = append(names, fmt.Sprintf("kopia/p%d", i))
+		items = append(items, &azcontainer.BlobItem{Name: to.Ptr(names[i])})
+	}
+
+	// pages of 3 items
+	o, container := newListingObjectStore(items, nil, listing{pageSize: 3})
+	objects, err := o.ListObjects("b", "kopia/")
+	require.NoError(t, err)
+	assert.Equal(t, names, objects)
+	assert.Equal(t, 4, container.pages)
+
+	// over the limit
+	o, _ = newListingObjectStore(items, nil, listing{pageSize: 3, maxResults: 9})
+	_, err = o.ListObjects("b", "kopia/")
+	assert.EqualError(t, err, `listing of prefix "kopia/" returned more than 9 items (the limit set by config key "maxListResults")`)
+
+	// within the limit
+	o, _ = newListingObjectStore(items, nil, listing{maxResults: 10, warningThreshold: 5})
+	objects, err = o.ListObjects("b", "kopia/")
+	require.NoError(t, err)
+	assert.Len(t, objects, 10)
+}
+
+func TestListCommonPrefixes(t *testing.T) {
+	prefixes := []string{"backups/b1/", "backups/b2/", "backups/b3/"}
+
+	o, container := newListingObjectStore(nil, prefixes, listing{pageSize: 2})
+	result, err := o.ListCommonPrefixes("b", "backups/", "/")
+	require.NoError(t, err)
+	assert.Equal(t, prefixes, result)
+	assert.Equal(t, 2, container.pages)
+
+	// empty listing
+	o, _ = newListingObjectStore(nil, nil, listing{})
+	result, err = o.ListCommonPrefixes("b", "backups/", "/")
+	require.NoError(t, err)
+	assert.Empty(t, result)
+}
+
+func TestListIterator(t *testing.T) {
+	var items []*azcontainer.BlobItem
+	for i := 0; i < 5; i++ {
+		items = append(items, &azcontainer.BlobItem{Name: to.Ptr(fmt.Sprintf("k%d", i))})
+	}
+	o, container := newListingObjectStore(items, nil, listing{pageSize: 2})
+
+	// the pages are fetched as the items are iterated over
+	it := o.iterateObjects(context.Background(), "b", "")
+	require.True(t, it.Next())
+	require.True(t, it.Next())
+	assert.Equal(t, "k1", *it.Item().Name)
+	assert.Equal(t, 1, container.pages)
+
+	names := []string{"k0", "k1"}
+	for it.Next() {
+		names = append(names, *it.Item().Name)
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []string{"k0", "k1", "k2", "k3", "k4"}, names)
+	assert.Equal(t, 3, container.pages)
+}
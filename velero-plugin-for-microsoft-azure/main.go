@@ -17,17 +17,34 @@ limitations under the License.
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	veleroplugin "github.com/vmware-tanzu/velero/pkg/plugin/framework"
 )
 
 func main() {
+	if ok, err := runCommand(os.Args[1:]); ok {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if address := os.Getenv(metricsAddressEnvVar); address != "" {
+		serveMetrics(logrus.New(), address)
+	}
+	shutdownTracing := setupTracing(logrus.New())
+
 	veleroplugin.NewServer().
 		BindFlags(pflag.CommandLine).
 		RegisterObjectStore("velero.io/azure", newAzureObjectStore).
 		RegisterVolumeSnapshotter("velero.io/azure", newAzureVolumeSnapshotter).
 		Serve()
+	shutdownTracing()
 }
 
 func newAzureObjectStore(logger logrus.FieldLogger) (interface{}, error) {
@@ -0,0 +1,173 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	metricsNamespace = "velero_azure"
+
+	// the environment variable holding the address to expose the metrics on, e.g. ":8086"
+	metricsAddressEnvVar = "VELERO_AZURE_METRICS_ADDRESS"
+	metricsPath          = "/metrics"
+)
+
+var (
+	objectStoreOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "object_store_operation_duration_seconds",
+		Help:      "Duration of the object store operations, by operation. The duration of GetObject is the time to the first byte.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900},
+	}, []string{"operation"})
+	objectStoreErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "object_store_errors_total",
+		Help:      "Failed object store operations, by operation and Azure error code.",
+	}, []string{"operation", "error_code"})
+	objectStoreUploadedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "object_store_uploaded_bytes_total",
+		Help:      "Bytes uploaded to the object store, after compression and encryption.",
+	})
+	objectStoreDownloadedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "object_store_downloaded_bytes_total",
+		Help:      "Bytes downloaded from the object store, before decryption and decompression.",
+	})
+	objectStoreStagedBlocks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "object_store_staged_blocks_total",
+		Help:      "Blocks staged by uploads to the object store.",
+	})
+
+	volumeSnapshotterOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "volume_snapshotter_operation_duration_seconds",
+		Help:      "Duration of the volume snapshotter operations, by operation.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800},
+	}, []string{"operation"})
+	volumeSnapshotterErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "volume_snapshotter_errors_total",
+		Help:      "Failed volume snapshotter operations, by operation and Azure error code.",
+	}, []string{"operation", "error_code"})
+	volumeSnapshotterPollIterations = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "volume_snapshotter_poll_iterations",
+		Help:      "Number of polls of the long-running operations of the volume snapshotter until they completed, by operation.",
+		Buckets:   []float64{1, 2, 5, 10, 20, 50, 100},
+	}, []string{"operation"})
+
+	throttledRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "throttled_requests_total",
+		Help:      "Requests throttled by Azure, by operation.",
+	}, []string{"operation"})
+	armRateLimitWaitSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "arm_rate_limit_wait_seconds",
+		Help:      "Time ARM compute requests waited for the client-side rate limiter, by kind of request (read or write).",
+		Buckets:   []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60, 120},
+	}, []string{"kind"})
+)
+
+// errorCode returns the Azure error code of the error, or its HTTP status code when Azure
+// didn't return one
+func errorCode(err error) string {
+	var responseErr *azcore.ResponseError
+	var timeoutErr *TimeoutError
+	switch {
+	case errors.As(err, &timeoutErr):
+		return "Timeout"
+	case errors.As(err, &responseErr) && responseErr.ErrorCode != "":
+		return responseErr.ErrorCode
+	case errors.As(err, &responseErr):
+		return strconv.Itoa(responseErr.StatusCode)
+	default:
+		return "Unknown"
+	}
+}
+
+// isThrottled returns whether the response is the one of a request throttled by Azure, ARM
+// throttles with 429 and the storage service with 503
+// ref. https://learn.microsoft.com/en-us/azure/storage/blobs/scalability-targets#partition-server-busy
+func isThrottled(resp *http.Response) bool {
+	return resp != nil && (resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("x-ms-error-code") == "ServerBusy")
+}
+
+// observeObjectStoreOperation records the duration and the error of an object store
+// operation, it's meant to be deferred with the named error result of the operation
+func observeObjectStoreOperation(operation string, start time.Time, err *error) {
+	objectStoreOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		objectStoreErrors.WithLabelValues(operation, errorCode(*err)).Inc()
+	}
+}
+
+// observeVolumeSnapshotterOperation records the duration and the error of a volume
+// snapshotter operation, it's meant to be deferred with the named error result of the operation
+func observeVolumeSnapshotterOperation(operation string, start time.Time, err *error) {
+	volumeSnapshotterOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		volumeSnapshotterErrors.WithLabelValues(operation, errorCode(*err)).Inc()
+	}
+}
+
+// countingReadCloser counts the bytes read through it
+type countingReadCloser struct {
+	io.ReadCloser
+	counter prometheus.Counter
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(float64(n))
+	return n, err
+}
+
+// serveMetrics exposes the metrics over HTTP on the address. The plugin keeps working when the
+// address can't be listened on, e.g. when another plugin process already listens on it.
+func serveMetrics(log logrus.FieldLogger, address string) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.WithError(err).Warnf("Unable to listen on %s, the metrics aren't exposed", address)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.WithError(err).Warn("Metrics server stopped")
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, "BlobNotFound", errorCode(errors.WithStack(&azcore.ResponseError{StatusCode: 404, ErrorCode: "BlobNotFound"})))
+	assert.Equal(t, "500", errorCode(&azcore.ResponseError{StatusCode: 500}))
+	assert.Equal(t, "Timeout", errorCode(&TimeoutError{Operation: operationGet, Err: &azcore.ResponseError{StatusCode: 500}}))
+	assert.Equal(t, "Unknown", errorCode(errors.New("bad")))
+}
+
+func TestIsThrottled(t *testing.T) {
+	assert.False(t, isThrottled(nil))
+	assert.True(t, isThrottled(&http.Response{StatusCode: http.StatusTooManyRequests}))
+	assert.True(t, isThrottled(&http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"X-Ms-Error-Code": {"ServerBusy"}}}))
+	assert.False(t, isThrottled(&http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}))
+}
+
+func TestObjectStoreMetrics(t *testing.T) {
+	errs := testutil.ToFloat64(objectStoreErrors.WithLabelValues("DeleteObject", "AuthorizationPermissionMismatch"))
+	blob := new(mockBlob)
+	blob.On("Delete", mock.Anything).Return(&azcore.ResponseError{StatusCode: 403, ErrorCode: "AuthorizationPermissionMismatch"})
+	blobGetter := new(mockBlobGetter)
+	blobGetter.On("getBlob", "b", "k").Return(blob)
+
+	o := &ObjectStore{log: logrus.New(), blobGetter: blobGetter}
+	require.Error(t, o.DeleteObject("b", "k"))
+	assert.Equal(t, errs+1, testutil.ToFloat64(objectStoreErrors.WithLabelValues("DeleteObject", "AuthorizationPermissionMismatch")))
+
+	downloaded := testutil.ToFloat64(objectStoreDownloadedBytes)
+	body, err := o.decodeObject("k", io.NopCloser(strings.NewReader("content")), nil)
+	require.NoError(t, err)
+	_, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, downloaded+7, testutil.ToFloat64(objectStoreDownloadedBytes))
+}
+
+func TestObserveVolumeSnapshotterOperation(t *testing.T) {
+	errs := testutil.ToFloat64(volumeSnapshotterErrors.WithLabelValues("CreateSnapshot", "OperationNotAllowed"))
+	operation := func() (err error) {
+		defer observeVolumeSnapshotterOperation("CreateSnapshot", time.Now(), &err)
+		return &azcore.ResponseError{StatusCode: 409, ErrorCode: "OperationNotAllowed"}
+	}
+	require.Error(t, operation())
+	assert.Equal(t, errs+1, testutil.ToFloat64(volumeSnapshotterErrors.WithLabelValues("CreateSnapshot", "OperationNotAllowed")))
+}
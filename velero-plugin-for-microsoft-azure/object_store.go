@@ -19,14 +19,25 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"hash/crc64"
 	"io"
+	"maps"
+	"math"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	azcontainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
@@ -34,6 +45,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 
 	veleroplugin "github.com/vmware-tanzu/velero/pkg/plugin/framework"
 	"github.com/vmware-tanzu/velero/pkg/util/azure"
@@ -45,31 +57,71 @@ const (
 	// ref. https://docs.microsoft.com/en-us/rest/api/storageservices/put-block#uri-parameters
 	maxBlockSize     = 100 * 1024 * 1024
 	defaultBlockSize = 1 * 1024 * 1024
+
+	uploadConcurrencyConfigKey = "uploadConcurrency"
+	// every in-flight block holds a buffer of blockSize bytes, so the concurrency
+	// is capped to keep the memory used by a single upload bounded
+	maxUploadConcurrency     = 64
+	defaultUploadConcurrency = 1
+
+	checksumAlgorithmConfigKey = "checksumAlgorithm"
+	checksumAlgorithmMD5       = "MD5"
+	checksumAlgorithmCRC64     = "CRC64"
+	checksumAlgorithmNone      = "None"
+	// how many times a block is staged before giving up when Azure keeps rejecting its checksum
+	maxChecksumAttempts = 3
+
+	verifyDownloadsConfigKey = "verifyDownloads"
+
+	downloadConcurrencyConfigKey       = "downloadConcurrency"
+	downloadRangeSizeConfigKey         = "downloadRangeSizeInBytes"
+	parallelDownloadThresholdConfigKey = "parallelDownloadThresholdInBytes"
+	maxDownloadConcurrency             = 64
+	defaultDownloadConcurrency         = 1
+	maxDownloadRangeSize               = 100 * 1024 * 1024
+	defaultDownloadRangeSize           = 4 * 1024 * 1024
+	defaultParallelDownloadThreshold   = 16 * 1024 * 1024
 )
 
+// crc64Table uses the polynomial Azure Storage computes transactional CRC64 checksums with
+// ref. https://learn.microsoft.com/en-us/rest/api/storageservices/put-block#request-headers
+var crc64Table = crc64.MakeTable(0x9A6C9329AC4BC9B5)
+
 type containerGetter interface {
-	getContainer(bucket string) container
+	// getContainer returns the container, whose requests are part of the operation of the context
+	getContainer(ctx context.Context, bucket string) container
 }
 
 type azureContainerGetter struct {
 	serviceClient *service.Client
+	requests      requestOptions
 }
 
-func (cg *azureContainerGetter) getContainer(bucket string) container {
+func (cg *azureContainerGetter) getContainer(ctx context.Context, bucket string) container {
 	containerClient := cg.serviceClient.NewContainerClient(bucket)
 
 	return &azureContainer{
+		ctx:             ctx,
 		containerClient: containerClient,
+		requests:        cg.requests,
 	}
 }
 
 type container interface {
 	ListBlobs(params *azcontainer.ListBlobsFlatOptions) *runtime.Pager[azcontainer.ListBlobsFlatResponse]
 	ListBlobsHierarchy(delimiter string, listOptions *azcontainer.ListBlobsHierarchyOptions) *runtime.Pager[azcontainer.ListBlobsHierarchyResponse]
+	// DeleteBlobs deletes the blobs with a single batch request. The returned errors are the
+	// ones of the individual deletions, in the order of the names.
+	DeleteBlobs(names []string) ([]error, error)
+	// DeleteVersions deletes versions of blobs with a single batch request, like DeleteBlobs
+	DeleteVersions(versions []blobVersion) ([]error, error)
 }
 
 type azureContainer struct {
+	// the context of the operation the container is used by
+	ctx             context.Context
 	containerClient *azcontainer.Client
+	requests        requestOptions
 }
 
 func (c *azureContainer) ListBlobs(params *azcontainer.ListBlobsFlatOptions) *runtime.Pager[azcontainer.ListBlobsFlatResponse] {
@@ -80,39 +132,102 @@ func (c *azureContainer) ListBlobsHierarchy(delimiter string, listOptions *azcon
 	return c.containerClient.NewListBlobsHierarchyPager(delimiter, listOptions)
 }
 
+func (c *azureContainer) DeleteBlobs(names []string) ([]error, error) {
+	versions := make([]blobVersion, len(names))
+	for i, name := range names {
+		versions[i] = blobVersion{Name: name}
+	}
+	return c.DeleteVersions(versions)
+}
+
+func (c *azureContainer) DeleteVersions(versions []blobVersion) ([]error, error) {
+	batch, err := c.containerClient.NewBatchBuilder()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, version := range versions {
+		var options *azcontainer.BatchDeleteOptions
+		if version.VersionID != "" {
+			options = &azcontainer.BatchDeleteOptions{VersionID: to.Ptr(version.VersionID)}
+		}
+		if err := batch.Delete(version.Name, options); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	ctx, done := c.requests.start(c.ctx, operationDelete, "ContainerClient.SubmitBatch")
+	res, err := c.containerClient.SubmitBatch(ctx, batch, nil)
+	if err = done(err); err != nil {
+		return nil, err
+	}
+
+	return batchDeleteErrors(len(versions), res.Responses), nil
+}
+
+// batchDeleteErrors returns the errors of the count deletions of a batch request from the
+// responses of its subrequests, in the order of the subrequests. A deletion without a response
+// isn't known to have succeeded, so it's reported as failed.
+func batchDeleteErrors(count int, responses []*azcontainer.BatchResponseItem) []error {
+	errs := make([]error, count)
+	for i := range errs {
+		errs[i] = errors.New("no response for subrequest")
+	}
+	// the content ID of a subrequest is its index in the batch
+	for _, item := range responses {
+		if item != nil && item.ContentID != nil && *item.ContentID >= 0 && *item.ContentID < count {
+			errs[*item.ContentID] = item.Error
+		}
+	}
+	return errs
+}
+
 type blobGetter interface {
-	getBlob(bucket, key string) blob
+	// getBlob returns the blob, whose requests are part of the operation of the context
+	getBlob(ctx context.Context, bucket, key string) blob
 }
 
 type azureBlobGetter struct {
 	serviceClient *service.Client
+	requests      requestOptions
 }
 
-func (bg *azureBlobGetter) getBlob(bucket, key string) blob {
+func (bg *azureBlobGetter) getBlob(ctx context.Context, bucket, key string) blob {
 	containerClient := bg.serviceClient.NewContainerClient(bucket)
 	blobClient := containerClient.NewBlockBlobClient(key)
 	return &azureBlob{
+		ctx:           ctx,
 		container:     bucket,
 		blob:          key,
 		blobClient:    blobClient,
 		serviceClient: bg.serviceClient,
+		requests:      bg.requests,
 	}
 }
 
 type blob interface {
 	PutBlock(blockID string, chunk []byte, options *blockblob.StageBlockOptions) error
 	PutBlockList(blocks []string, options *blockblob.CommitBlockListOptions) error
-	Exists() (bool, error)
-	Get(options *azblob.DownloadStreamOptions) (io.ReadCloser, error)
+	GetUncommittedBlocks() ([]string, error)
+	GetProperties(options *azblobblob.GetPropertiesOptions) (azblobblob.GetPropertiesResponse, error)
+	// Get returns the content of the blob along with its metadata
+	Get(options *azblob.DownloadStreamOptions) (io.ReadCloser, map[string]*string, error)
+	SetTier(tier azblobblob.AccessTier, options *azblobblob.SetTierOptions) error
 	Delete(options *azblob.DeleteBlobOptions) error
+	DeleteVersion(versionID string) error
+	Undelete() error
+	// PromoteVersion restores a previous version of the blob as its current version
+	PromoteVersion(versionID string) error
 	GetSASURI(duration time.Duration, sharedKeyCredential *azblob.SharedKeyCredential) (string, error)
 }
 
 type azureBlob struct {
+	// the context of the operation the blob is used by
+	ctx           context.Context
 	container     string
 	blob          string
 	blobClient    *blockblob.Client
 	serviceClient *service.Client
+	requests      requestOptions
 }
 
 type nopCloser struct {
@@ -129,37 +244,89 @@ func NopCloser(rs io.ReadSeeker) io.ReadSeekCloser {
 }
 
 func (b *azureBlob) PutBlock(blockID string, chunk []byte, options *blockblob.StageBlockOptions) error {
-	_, err := b.blobClient.StageBlock(context.TODO(), blockID, NopCloser(bytes.NewReader(chunk)), options)
-	return err
+	ctx, done := b.requests.start(b.ctx, operationPutBlock, "BlockBlobClient.StageBlock")
+	_, err := b.blobClient.StageBlock(ctx, blockID, NopCloser(bytes.NewReader(chunk)), options)
+	return done(err)
 }
 
 func (b *azureBlob) PutBlockList(blocks []string, options *blockblob.CommitBlockListOptions) error {
-	_, err := b.blobClient.CommitBlockList(context.TODO(), blocks, options)
-	return err
+	ctx, done := b.requests.start(b.ctx, operationCommit, "BlockBlobClient.CommitBlockList")
+	_, err := b.blobClient.CommitBlockList(ctx, blocks, options)
+	return done(err)
 }
 
-func (b *azureBlob) Exists() (bool, error) {
-	_, err := b.blobClient.GetProperties(context.TODO(), nil)
-	if err == nil {
-		return true, nil
+// GetUncommittedBlocks returns the IDs of the blocks staged but not yet committed
+func (b *azureBlob) GetUncommittedBlocks() ([]string, error) {
+	ctx, done := b.requests.start(b.ctx, operationList, "BlockBlobClient.GetBlockList")
+	res, err := b.blobClient.GetBlockList(ctx, blockblob.BlockListTypeUncommitted, nil)
+	if err = done(err); err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, nil
+		}
+		return nil, err
 	}
-	if bloberror.HasCode(err, bloberror.ContainerNotFound, bloberror.BlobNotFound) {
-		return false, nil
+
+	var blockIDs []string
+	for _, block := range res.UncommittedBlocks {
+		blockIDs = append(blockIDs, *block.Name)
 	}
-	return false, err
+	return blockIDs, nil
 }
 
-func (b *azureBlob) Get(options *azblob.DownloadStreamOptions) (io.ReadCloser, error) {
-	res, err := b.blobClient.BlobClient().DownloadStream(context.TODO(), options)
+func (b *azureBlob) GetProperties(options *azblobblob.GetPropertiesOptions) (azblobblob.GetPropertiesResponse, error) {
+	ctx, done := b.requests.start(b.ctx, operationGet, "BlobClient.GetProperties")
+	res, err := b.blobClient.GetProperties(ctx, options)
+	return res, done(err)
+}
+
+func (b *azureBlob) Get(options *azblob.DownloadStreamOptions) (io.ReadCloser, map[string]*string, error) {
+	// the timeout only covers the response, the request ends once the body is closed
+	ctx, received, done := b.requests.startDownload(b.ctx, operationGet, "BlobClient.DownloadStream")
+	res, err := b.blobClient.BlobClient().DownloadStream(ctx, options)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(done(err))
 	}
-	return res.Body, nil
+	received()
+	body := &timeoutReadCloser{body: res.Body, ctx: ctx, timeouts: b.requests.timeouts, operation: operationGet, done: done}
+	return body, res.Metadata, nil
+}
+
+func (b *azureBlob) SetTier(tier azblobblob.AccessTier, options *azblobblob.SetTierOptions) error {
+	ctx, done := b.requests.start(b.ctx, operationSetTier, "BlobClient.SetTier")
+	_, err := b.blobClient.SetTier(ctx, tier, options)
+	return done(err)
 }
 
 func (b *azureBlob) Delete(options *azblob.DeleteBlobOptions) error {
-	_, err := b.blobClient.Delete(context.TODO(), options)
-	return err
+	ctx, done := b.requests.start(b.ctx, operationDelete, "BlobClient.Delete")
+	_, err := b.blobClient.Delete(ctx, options)
+	return done(err)
+}
+
+func (b *azureBlob) DeleteVersion(versionID string) error {
+	versionClient, err := b.blobClient.WithVersionID(versionID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	ctx, done := b.requests.start(b.ctx, operationDelete, "BlobClient.Delete")
+	_, err = versionClient.Delete(ctx, nil)
+	return done(err)
+}
+
+func (b *azureBlob) Undelete() error {
+	ctx, done := b.requests.start(b.ctx, operationUndelete, "BlobClient.Undelete")
+	_, err := b.blobClient.Undelete(ctx, nil)
+	return done(err)
+}
+
+func (b *azureBlob) PromoteVersion(versionID string) error {
+	versionClient, err := b.blobClient.WithVersionID(versionID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	ctx, done := b.requests.start(b.ctx, operationPromoteVersion, "BlobClient.StartCopyFromURL")
+	_, err = b.blobClient.StartCopyFromURL(ctx, versionClient.URL(), nil)
+	return done(err)
 }
 
 // When the sharedKeyCredential is provided service SAS is used else delegation SAS is used
@@ -184,9 +351,10 @@ func (b *azureBlob) GetSASURI(ttl time.Duration, sharedKeyCredential *azblob.Sha
 			Start:  to.Ptr(startTime.Format(sas.TimeFormat)),
 			Expiry: to.Ptr(expiryTime.Format(sas.TimeFormat)),
 		}
-		udc, err = b.serviceClient.GetUserDelegationCredential(context.TODO(), info, nil)
+		ctx, done := b.requests.start(b.ctx, operationSAS, "ServiceClient.GetUserDelegationCredential")
+		udc, err = b.serviceClient.GetUserDelegationCredential(ctx, info, nil)
 
-		if err != nil {
+		if err = done(err); err != nil {
 			return "", err
 		}
 		queryParam, err = blobSignatureValues.SignWithUserDelegation(udc)
@@ -207,6 +375,36 @@ type ObjectStore struct {
 	containerGetter containerGetter
 	blobGetter      blobGetter
 	blockSize       int
+	// the number of blocks staged in parallel by PutObject
+	uploadConcurrency int
+	// the transactional checksum sent along with every staged block
+	checksumAlgorithm string
+	// whether GetObject verifies downloads against the stored MD5 of the object
+	verifyDownloads bool
+	// objects larger than the threshold are downloaded as downloadConcurrency parallel ranges
+	downloadConcurrency       int
+	downloadRangeSize         int64
+	parallelDownloadThreshold int64
+	// the access tier objects are committed in
+	accessTiers *accessTierSelector
+	rehydration rehydration
+	// the customer-provided key objects are encrypted with, if any
+	cpkInfo *azblobblob.CPKInfo
+	// the encryption scope objects are written with, if any
+	cpkScopeInfo *azblobblob.CPKScopeInfo
+	// wraps the data keys of objects encrypted client-side, if any
+	keyWrapper keyWrapper
+	// the codec objects are compressed with, if any
+	compression string
+	// the immutability policy and legal hold objects are committed with
+	immutability immutability
+	// returns the management plane of the storage account, nil if its resource group or
+	// subscription isn't known. It's only accessed by the settings and commands that need it.
+	storageAccount func() (storageAccount, error)
+	// whether DeleteObject deletes the previous versions of objects as well
+	deleteAllVersions bool
+	listing           listing
+	requests          requestOptions
 	// we need to keep the credential here to create the sas url
 	sharedKeyCredential *azblob.SharedKeyCredential
 }
@@ -222,6 +420,40 @@ func (o *ObjectStore) Init(config map[string]string) error {
 		azure.BSLConfigStorageAccount,
 		azure.BSLConfigSubscriptionID,
 		blockSizeConfigKey,
+		uploadConcurrencyConfigKey,
+		checksumAlgorithmConfigKey,
+		verifyDownloadsConfigKey,
+		downloadConcurrencyConfigKey,
+		downloadRangeSizeConfigKey,
+		parallelDownloadThresholdConfigKey,
+		accessTierConfigKey,
+		accessTierOverridesConfigKey,
+		rehydrateConfigKey,
+		rehydrateTierConfigKey,
+		rehydratePriorityConfigKey,
+		customerProvidedKeyConfigKey,
+		encryptionScopeConfigKey,
+		clientSideEncryptionKeyFileConfigKey,
+		clientSideEncryptionPreviousKeyFilesConfigKey,
+		compressionConfigKey,
+		immutabilityPeriodConfigKey,
+		immutabilityPolicyModeConfigKey,
+		legalHoldConfigKey,
+		deleteAllVersionsConfigKey,
+		listPageSizeConfigKey,
+		maxListResultsConfigKey,
+		listWarningThresholdConfigKey,
+		listTimeoutConfigKey,
+		getTimeoutConfigKey,
+		putBlockTimeoutConfigKey,
+		commitTimeoutConfigKey,
+		deleteTimeoutConfigKey,
+		sasTimeoutConfigKey,
+		maxRetriesConfigKey,
+		retryDelayConfigKey,
+		maxRetryDelayConfigKey,
+		retryStatusCodesConfigKey,
+		tryTimeoutConfigKey,
 		azure.BSLConfigActiveDirectoryAuthorityURI,
 		azure.BSLConfigStorageAccountURI,
 		azure.BSLConfigUseAAD,
@@ -231,22 +463,147 @@ func (o *ObjectStore) Init(config map[string]string) error {
 		return err
 	}
 
-	client, cred, err := azure.NewStorageClient(o.log, config)
+	uploadConcurrency, err := getUploadConcurrency(config)
+	if err != nil {
+		return err
+	}
+
+	checksumAlgorithm, err := getChecksumAlgorithm(config)
+	if err != nil {
+		return err
+	}
+
+	if o.verifyDownloads, err = getVerifyDownloads(config, checksumAlgorithm); err != nil {
+		return err
+	}
+
+	downloadConcurrency, err := getIntConfig(config, downloadConcurrencyConfigKey, defaultDownloadConcurrency, 1, maxDownloadConcurrency)
+	if err != nil {
+		return err
+	}
+	o.downloadConcurrency = int(downloadConcurrency)
+	if o.downloadRangeSize, err = getIntConfig(config, downloadRangeSizeConfigKey, defaultDownloadRangeSize, 1, maxDownloadRangeSize); err != nil {
+		return err
+	}
+	if o.parallelDownloadThreshold, err = getIntConfig(config, parallelDownloadThresholdConfigKey, defaultParallelDownloadThreshold, 0, math.MaxInt64); err != nil {
+		return err
+	}
+
+	if o.accessTiers, err = newAccessTierSelector(config); err != nil {
+		return err
+	}
+	if o.rehydration, err = getRehydration(config); err != nil {
+		return err
+	}
+	if o.cpkInfo, err = getCustomerProvidedKey(config); err != nil {
+		return err
+	}
+	o.storageAccount = func() (storageAccount, error) {
+		return newStorageAccount(config)
+	}
+	if config[encryptionScopeConfigKey] != "" {
+		if o.cpkInfo != nil {
+			return errors.Errorf("config keys %q and %q can't be used together", customerProvidedKeyConfigKey, encryptionScopeConfigKey)
+		}
+		account, err := o.storageAccount()
+		if err != nil {
+			return err
+		}
+		if o.cpkScopeInfo, err = getEncryptionScope(o.log, account, config[encryptionScopeConfigKey]); err != nil {
+			return err
+		}
+	}
+
+	if o.keyWrapper, err = getKeyWrapper(config); err != nil {
+		return err
+	}
+	if o.compression, err = getCompression(config); err != nil {
+		return err
+	}
+	if o.immutability, err = getImmutability(config); err != nil {
+		return err
+	}
+	if val := config[deleteAllVersionsConfigKey]; val != "" {
+		if o.deleteAllVersions, err = strconv.ParseBool(val); err != nil {
+			return errors.Wrapf(err, "unable to parse value %q for config key %q (expected a boolean value)", val, deleteAllVersionsConfigKey)
+		}
+	}
+	if o.listing, err = getListing(config); err != nil {
+		return err
+	}
+	if o.requests.timeouts, err = getOperationTimeouts(config); err != nil {
+		return err
+	}
+	if o.requests.retry, err = getRetryPolicy(o.log, config); err != nil {
+		return err
+	}
+	o.requests.tracer = newTracingProvider().NewTracer(azblobModuleName, "")
+	// the data protection of the storage account only matters to the deletion of previous
+	// versions, and to the recovery commands which log it themselves
+	if o.deleteAllVersions {
+		o.logDataProtection(false)
+	}
+
+	serviceClient, cred, err := newServiceClient(o.log, config)
 	if err != nil {
 		return err
 	}
 	o.sharedKeyCredential = cred
 
 	o.containerGetter = &azureContainerGetter{
-		serviceClient: client.ServiceClient(),
+		serviceClient: serviceClient,
+		requests:      o.requests,
 	}
 	o.blobGetter = &azureBlobGetter{
-		serviceClient: client.ServiceClient(),
+		serviceClient: serviceClient,
+		requests:      o.requests,
 	}
 	o.blockSize = getBlockSize(o.log, config)
+	o.uploadConcurrency = uploadConcurrency
+	o.checksumAlgorithm = checksumAlgorithm
 	return nil
 }
 
+// newServiceClient returns the client of the blob service of the storage account, whose calls
+// and requests are traced. The client of Velero, which resolves the URI and the credential of
+// the storage account, can't be given a tracing provider, so it's recreated with one.
+func newServiceClient(log logrus.FieldLogger, config map[string]string) (*service.Client, *azblob.SharedKeyCredential, error) {
+	client, sharedKeyCredential, err := azure.NewStorageClient(log, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	creds, err := azure.LoadCredentials(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientOptions, err := azure.GetClientOptions(config, creds)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientOptions.PerRetryPolicies = append(clientOptions.PerRetryPolicies, retryLogPolicy{})
+	clientOptions.TracingProvider = newTracingProvider()
+	options := &service.ClientOptions{ClientOptions: clientOptions}
+
+	if sharedKeyCredential != nil {
+		serviceClient, err := service.NewClientWithSharedKeyCredential(client.URL(), sharedKeyCredential, options)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to create blob client with the storage account access key")
+		}
+		return serviceClient, sharedKeyCredential, nil
+	}
+
+	credential, err := azure.NewCredential(creds, clientOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	serviceClient, err := service.NewClient(client.URL(), credential, options)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create blob client with the Azure AD credential")
+	}
+	return serviceClient, nil, nil
+}
+
 func getBlockSize(log logrus.FieldLogger, config map[string]string) int {
 	val, ok := config[blockSizeConfigKey]
 	if !ok {
@@ -273,115 +630,415 @@ func getBlockSize(log logrus.FieldLogger, config map[string]string) int {
 	return blockSize
 }
 
-func (o *ObjectStore) PutObject(bucket, key string, body io.Reader) error {
-	blob := o.blobGetter.getBlob(bucket, key)
+func getUploadConcurrency(config map[string]string) (int, error) {
+	concurrency, err := getIntConfig(config, uploadConcurrencyConfigKey, defaultUploadConcurrency, 1, maxUploadConcurrency)
+	return int(concurrency), err
+}
+
+// getIntConfig returns the integer value of the config key, or the default value if it isn't set
+func getIntConfig(config map[string]string, key string, defaultValue, minValue, maxValue int64) (int64, error) {
+	val := config[key]
+	if val == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to parse value %q for config key %q (expected an integer)", val, key)
+	}
+	if parsed < minValue || parsed > maxValue {
+		return 0, errors.Errorf("value %d for config key %q must be between %d and %d", parsed, key, minValue, maxValue)
+	}
+
+	return parsed, nil
+}
+
+func getChecksumAlgorithm(config map[string]string) (string, error) {
+	val := config[checksumAlgorithmConfigKey]
+	if val == "" {
+		return checksumAlgorithmNone, nil
+	}
+
+	for _, algorithm := range []string{checksumAlgorithmMD5, checksumAlgorithmCRC64, checksumAlgorithmNone} {
+		if strings.EqualFold(val, algorithm) {
+			return algorithm, nil
+		}
+	}
+	return "", errors.Errorf("unsupported value %q for config key %q (expected one of %s, %s or %s)", val, checksumAlgorithmConfigKey,
+		checksumAlgorithmMD5, checksumAlgorithmCRC64, checksumAlgorithmNone)
+}
+
+// getVerifyDownloads returns whether to verify downloaded objects, which requires uploading them
+// with a checksum since only then is their MD5 stored
+func getVerifyDownloads(config map[string]string, checksumAlgorithm string) (bool, error) {
+	val := config[verifyDownloadsConfigKey]
+	if val == "" {
+		return false, nil
+	}
+
+	verifyDownloads, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to parse value %q for config key %q (expected a boolean value)", val, verifyDownloadsConfigKey)
+	}
+	if verifyDownloads && checksumAlgorithm == checksumAlgorithmNone {
+		return false, errors.Errorf("config key %q requires config key %q to be set to %s or %s, objects uploaded without checksum have no MD5 to verify",
+			verifyDownloadsConfigKey, checksumAlgorithmConfigKey, checksumAlgorithmMD5, checksumAlgorithmCRC64)
+	}
+	return verifyDownloads, nil
+}
+
+func (o *ObjectStore) PutObject(bucket, key string, body io.Reader) (err error) {
+	ctx, end := startObjectStoreOperation("PutObject", attribute.String("bucket", bucket), attribute.String("key", key))
+	defer end(&err)
+	blob := o.blobGetter.getBlob(ctx, bucket, key)
 	// Azure requires a blob/object to be chunked if it's larger than 256MB. Since we
 	// don't know ahead of time if the body is over this limit or not, and it would
 	// require reading the entire object into memory to determine the size, we use the
 	// chunking approach for all objects.
+	// blocks staged by a previous attempt that didn't get to commit them don't need to be
+	// staged again. The blocks of compressed or encrypted objects never match those of a
+	// previous attempt, so they aren't looked up.
+	var getStagedBlocks func() map[string]bool
+	if o.compression == "" && o.keyWrapper == nil {
+		getStagedBlocks = func() map[string]bool {
+			return o.getStagedBlocks(blob, key)
+		}
+	}
+
+	// the content is compressed before it is encrypted, encrypted content doesn't compress
+	metadata := map[string]*string{}
+	if o.compression != "" {
+		compressed := compressObject(o.compression, body)
+		defer compressed.Close()
+		body = compressed
+		metadata[compressionMetadataKey] = to.Ptr(o.compression)
+	}
+	// objects encrypted client-side get a new data key on every attempt, so the blocks
+	// of a previous attempt never match
+	if o.keyWrapper != nil {
+		var encryptionMetadata map[string]*string
+		if body, encryptionMetadata, err = encryptObject(o.keyWrapper, body); err != nil {
+			return err
+		}
+		maps.Copy(metadata, encryptionMetadata)
+	}
+
+	blockIDs, contentMD5, err := o.putBlocks(blob, body, getStagedBlocks)
+	if err != nil {
+		return err
+	}
+
+	options := &blockblob.CommitBlockListOptions{
+		Tier:         o.accessTiers.tierFor(key),
+		CPKInfo:      o.cpkInfo,
+		CPKScopeInfo: o.cpkScopeInfo,
+	}
+	if len(metadata) > 0 {
+		options.Metadata = metadata
+	}
+	o.immutability.apply(options)
+	if contentMD5 != nil {
+		// Azure doesn't validate the MD5 of a blob committed from blocks, it only stores it
+		// so that it can be verified when the blob is read back
+		options.HTTPHeaders = &azblobblob.HTTPHeaders{BlobContentMD5: contentMD5}
+	}
+
+	o.log.Debugf("Putting block list %v", blockIDs)
+	if err := blob.PutBlockList(blockIDs, options); err != nil {
+		return errors.Wrap(o.checkImmutability(blob, key, o.checkEncryptionKey(blob, key, err)), "error putting block list")
+	}
+
+	return nil
+}
+
+// putBlocks reads the body in chunks of blockSize and stages them as blocks of the blob,
+// keeping up to uploadConcurrency blocks in flight. The returned block IDs are in the
+// order the chunks were read from the body, which is the order they must be committed in.
+// Unless checksums are disabled, the MD5 of the whole body is returned as well. Blocks
+// returned by getStagedBlocks, if set, are already staged and are skipped. It's only called
+// for bodies filling the first block: most objects fit in a block, they're uploaded again
+// rather than paying for a lookup that usually finds nothing.
+func (o *ObjectStore) putBlocks(blob blob, body io.Reader, getStagedBlocks func() map[string]bool) ([]string, []byte, error) {
+	concurrency := o.uploadConcurrency
+	if concurrency < 1 {
+		concurrency = defaultUploadConcurrency
+	}
+
+	// the buffer pool doubles as the semaphore bounding the number of in-flight blocks:
+	// it starts out with one empty slot per worker and buffers are only allocated when
+	// a slot is used for the first time, so small objects don't pay for the full pool.
+	buffers := make(chan []byte, concurrency)
+	for i := 0; i < concurrency; i++ {
+		buffers <- nil
+	}
+
+	var contentHash hash.Hash
+	if o.checksumAlgorithm != "" && o.checksumAlgorithm != checksumAlgorithmNone {
+		contentHash = md5.New()
+	}
+
 	var (
-		block    = make([]byte, o.blockSize)
-		blockIDs []string
+		blockIDs     []string
+		stagedBlocks map[string]bool
+		offset       int64
+		wg           sync.WaitGroup
+		failOnce     sync.Once
+		failed       = make(chan struct{})
+		firstErr     error
 	)
+	fail := func(err error) {
+		failOnce.Do(func() {
+			firstErr = err
+			close(failed)
+		})
+	}
 
+loop:
 	for {
-		n, err := body.Read(block)
-		if n > 0 {
-			// blockID needs to be the same length for all blocks, so use a fixed width.
-			// ref. https://docs.microsoft.com/en-us/rest/api/storageservices/put-block#uri-parameters
-			blockID := fmt.Sprintf("%08d", len(blockIDs))
+		// stop reading from the body as soon as a block failed to upload
+		var block []byte
+		select {
+		case <-failed:
+			break loop
+		case block = <-buffers:
+		}
+		if block == nil {
+			block = make([]byte, o.blockSize)
+		}
 
-			o.log.Debugf("Putting block (id=%s) of length %d", blockID, n)
-			if putErr := blob.PutBlock(blockID, block[0:n], nil); putErr != nil {
-				return errors.Wrapf(putErr, "error putting block %s", blockID)
+		// fill the whole block unless the body ends, so that blocks have a predictable size
+		n, err := io.ReadFull(body, block)
+		if offset == 0 && err == nil && getStagedBlocks != nil {
+			stagedBlocks = getStagedBlocks()
+		}
+		if n > 0 {
+			blockID := getBlockID(offset, block[0:n])
+			blockIDs = append(blockIDs, blockID)
+			offset += int64(n)
+			if contentHash != nil {
+				contentHash.Write(block[0:n])
 			}
 
-			blockIDs = append(blockIDs, blockID)
+			if stagedBlocks[blockID] {
+				o.log.Debugf("Skipping block (id=%s) staged by a previous upload", blockID)
+				buffers <- block
+			} else {
+				wg.Add(1)
+				go func(blockID string, block []byte, n int) {
+					defer wg.Done()
+
+					o.log.Debugf("Putting block (id=%s) of length %d", blockID, n)
+					if putErr := o.putBlock(blob, blockID, block[0:n]); putErr != nil {
+						fail(errors.Wrapf(putErr, "error putting block %s", blockID))
+					}
+					buffers <- block
+				}(blockID, block, n)
+			}
+		} else {
+			buffers <- block
 		}
 
-		// got an io.EOF: we're done reading chunks from the body
-		if err == io.EOF {
+		// got an io.EOF or a partial block: we're done reading chunks from the body
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			break
 		}
 		// any other error: bubble it up
 		if err != nil {
-			return errors.Wrap(err, "error reading block from body")
+			fail(errors.Wrap(err, "error reading block from body"))
+			break
 		}
 	}
 
-	o.log.Debugf("Putting block list %v", blockIDs)
-	if err := blob.PutBlockList(blockIDs, nil); err != nil {
-		return errors.Wrap(err, "error putting block list")
+	wg.Wait()
+	if firstErr != nil {
+		return nil, nil, firstErr
 	}
 
-	return nil
+	if contentHash == nil {
+		return blockIDs, nil, nil
+	}
+	return blockIDs, contentHash.Sum(nil), nil
 }
 
-func (o *ObjectStore) ObjectExists(bucket, key string) (bool, error) {
-	blob := o.blobGetter.getBlob(bucket, key)
-	exists, err := blob.Exists()
+// getStagedBlocks returns the blocks of the blob staged by a previous upload that didn't get to
+// commit them
+func (o *ObjectStore) getStagedBlocks(blob blob, key string) map[string]bool {
+	uncommitted, err := blob.GetUncommittedBlocks()
 	if err != nil {
-		return false, errors.WithStack(err)
+		o.log.WithError(err).Warnf("Error getting the uncommitted blocks of %s, uploading all blocks", key)
 	}
-
-	return exists, nil
+	stagedBlocks := make(map[string]bool, len(uncommitted))
+	for _, blockID := range uncommitted {
+		stagedBlocks[blockID] = true
+	}
+	return stagedBlocks
 }
 
-func (o *ObjectStore) GetObject(bucket, key string) (io.ReadCloser, error) {
-	blob := o.blobGetter.getBlob(bucket, key)
-	return blob.Get(nil)
+// getBlockID derives the ID of a block from its offset in the object and its content, so
+// an interrupted upload of the same content can find out which blocks it already staged.
+func getBlockID(offset int64, chunk []byte) string {
+	// blockID needs to be the same length for all blocks and at most 64 bytes before
+	// being base64 encoded, so use a fixed width offset and a truncated content hash.
+	// ref. https://docs.microsoft.com/en-us/rest/api/storageservices/put-block#uri-parameters
+	sum := sha256.Sum256(chunk)
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%016x%s", offset, hex.EncodeToString(sum[:16]))))
 }
 
-func (o *ObjectStore) ListCommonPrefixes(bucket, prefix, delimiter string) ([]string, error) {
-	container := o.containerGetter.getContainer(bucket)
-	params := azcontainer.ListBlobsHierarchyOptions{
-		Prefix: &prefix,
+// putBlock stages a single block along with its transactional checksum. Azure rejects
+// a block whose content doesn't match the checksum, i.e. it got corrupted in transit,
+// in which case the block is staged again.
+func (o *ObjectStore) putBlock(blob blob, blockID string, chunk []byte) error {
+	options := &blockblob.StageBlockOptions{CPKInfo: o.cpkInfo, CPKScopeInfo: o.cpkScopeInfo}
+	switch o.checksumAlgorithm {
+	case checksumAlgorithmMD5:
+		sum := md5.Sum(chunk)
+		options.TransactionalValidation = azblobblob.TransferValidationTypeMD5(sum[:])
+	case checksumAlgorithmCRC64:
+		options.TransactionalValidation = azblobblob.TransferValidationTypeCRC64(crc64.Checksum(chunk, crc64Table))
 	}
 
-	var prefixes []string
-	pager := container.ListBlobsHierarchy(delimiter, &params)
-	for pager.More() {
-		page, err := pager.NextPage(context.TODO())
-		if err != nil {
-			return nil, err
+	var err error
+	for attempt := 1; attempt <= maxChecksumAttempts; attempt++ {
+		err = blob.PutBlock(blockID, chunk, options)
+		if err == nil {
+			objectStoreStagedBlocks.Inc()
+			objectStoreUploadedBytes.Add(float64(len(chunk)))
+			return nil
 		}
-
-		for _, prefix := range page.ListBlobsHierarchySegmentResponse.Segment.BlobPrefixes {
-			prefixes = append(prefixes, *prefix.Name)
+		if !bloberror.HasCode(err, bloberror.MD5Mismatch, bloberror.CRC64Mismatch) {
+			return err
 		}
+		o.log.WithError(err).Warnf("Checksum of block (id=%s) was rejected (attempt %d of %d)", blockID, attempt, maxChecksumAttempts)
 	}
 
-	return prefixes, nil
+	return errors.Wrapf(err, "checksum of block %s was rejected %d times, the data is being corrupted in transit", blockID, maxChecksumAttempts)
 }
 
-func (o *ObjectStore) ListObjects(bucket, prefix string) ([]string, error) {
-	container := o.containerGetter.getContainer(bucket)
-	params := azcontainer.ListBlobsFlatOptions{
-		Prefix: &prefix,
+func (o *ObjectStore) ObjectExists(bucket, key string) (_ bool, err error) {
+	ctx, end := startObjectStoreOperation("ObjectExists", attribute.String("bucket", bucket), attribute.String("key", key))
+	defer end(&err)
+	blob := o.blobGetter.getBlob(ctx, bucket, key)
+	props, err := blob.GetProperties(&azblobblob.GetPropertiesOptions{CPKInfo: o.cpkInfo})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.ContainerNotFound, bloberror.BlobNotFound) {
+			return false, nil
+		}
+		return false, errors.WithStack(o.checkEncryptionKey(blob, key, err))
+	}
+
+	// the object exists but it can't be read until it is rehydrated
+	if isArchived(props.AccessTier) {
+		o.log.Warn(o.archivedObjectError(blob, key, &props).Error())
 	}
 
-	var objects []string
-	pager := container.ListBlobs(&params)
-	for pager.More() {
-		page, err := pager.NextPage(context.TODO())
+	return true, nil
+}
+
+func (o *ObjectStore) GetObject(bucket, key string) (_ io.ReadCloser, err error) {
+	ctx, end := startObjectStoreOperation("GetObject", attribute.String("bucket", bucket), attribute.String("key", key))
+	defer end(&err)
+	blob := o.blobGetter.getBlob(ctx, bucket, key)
+	options := &azblob.DownloadStreamOptions{CPKInfo: o.cpkInfo}
+	if !o.verifyDownloads && o.downloadConcurrency <= 1 {
+		body, metadata, err := blob.Get(options)
+		if bloberror.HasCode(err, bloberror.BlobArchived) {
+			return nil, o.archivedObjectError(blob, key, nil)
+		}
 		if err != nil {
-			return nil, err
+			return nil, o.checkEncryptionKey(blob, key, err)
 		}
+		return o.decodeObject(key, body, metadata)
+	}
+
+	props, err := blob.GetProperties(&azblobblob.GetPropertiesOptions{CPKInfo: o.cpkInfo})
+	if err != nil {
+		return nil, errors.WithStack(o.checkEncryptionKey(blob, key, err))
+	}
+	if isArchived(props.AccessTier) {
+		return nil, o.archivedObjectError(blob, key, &props)
+	}
 
-		for _, blob := range page.ListBlobsFlatSegmentResponse.Segment.BlobItems {
-			objects = append(objects, *blob.Name)
+	// make sure all the downloaded content belongs to the version of the object the properties were read from
+	options.AccessConditions = &azblobblob.AccessConditions{
+		ModifiedAccessConditions: &azblobblob.ModifiedAccessConditions{IfMatch: props.ETag},
+	}
+
+	var body io.ReadCloser
+	if o.downloadConcurrency > 1 && props.ContentLength != nil && *props.ContentLength > o.parallelDownloadThreshold {
+		o.log.Debugf("Downloading %s (%d bytes) in ranges of %d bytes", key, *props.ContentLength, o.downloadRangeSize)
+		// the ranges still being downloaded are cancelled when the object is closed
+		rangesCtx, cancel := context.WithCancel(ctx)
+		rangesBlob := o.blobGetter.getBlob(rangesCtx, bucket, key)
+		body = newRangedReadCloser(o.log, rangesBlob, key, *props.ContentLength, o.downloadRangeSize, o.downloadConcurrency, options, o.requests.retry, cancel)
+	} else if body, _, err = blob.Get(options); err != nil {
+		return nil, o.checkEncryptionKey(blob, key, err)
+	}
+
+	// the stored MD5 is the one of the content as uploaded, i.e. before it is decrypted
+	if o.verifyDownloads {
+		if len(props.ContentMD5) == 0 {
+			o.log.Warnf("Object %s has no stored MD5, it can't be verified while downloading", key)
+		} else {
+			body = newVerifyingReadCloser(key, body, props.ContentMD5)
 		}
 	}
-	return objects, nil
+	return o.decodeObject(key, body, props.Metadata)
+}
+
+// decodeObject reverses the encryption and the compression of an object, as recorded in its metadata
+func (o *ObjectStore) decodeObject(key string, body io.ReadCloser, metadata map[string]*string) (io.ReadCloser, error) {
+	body = &countingReadCloser{ReadCloser: body, counter: objectStoreDownloadedBytes}
+	body, err := decryptObject(o.keyWrapper, key, body, metadata)
+	if err != nil {
+		return nil, err
+	}
+	return decompressObject(key, body, metadata)
+}
+
+func (o *ObjectStore) ListCommonPrefixes(bucket, prefix, delimiter string) (_ []string, err error) {
+	ctx, end := startObjectStoreOperation("ListCommonPrefixes", attribute.String("bucket", bucket), attribute.String("prefix", prefix))
+	defer end(&err)
+	return collectNames(o, o.iteratePrefixes(ctx, bucket, prefix, delimiter), func(prefix *azcontainer.BlobPrefix) string {
+		return *prefix.Name
+	}, prefix)
 }
 
-func (o *ObjectStore) DeleteObject(bucket string, key string) error {
-	blob := o.blobGetter.getBlob(bucket, key)
-	err := blob.Delete(nil)
-	return errors.WithStack(err)
+func (o *ObjectStore) ListObjects(bucket, prefix string) (_ []string, err error) {
+	ctx, end := startObjectStoreOperation("ListObjects", attribute.String("bucket", bucket), attribute.String("prefix", prefix))
+	defer end(&err)
+	return collectNames(o, o.iterateObjects(ctx, bucket, prefix), func(blob *azcontainer.BlobItem) string {
+		return *blob.Name
+	}, prefix)
 }
 
-func (o *ObjectStore) CreateSignedURL(bucket, key string, ttl time.Duration) (string, error) {
-	blob := o.blobGetter.getBlob(bucket, key)
+func (o *ObjectStore) DeleteObject(bucket string, key string) (err error) {
+	ctx, end := startObjectStoreOperation("DeleteObject", attribute.String("bucket", bucket), attribute.String("key", key))
+	defer end(&err)
+	blob := o.blobGetter.getBlob(ctx, bucket, key)
+	// deleting an object encrypted with a customer-provided key doesn't require the key
+	if err := blob.Delete(nil); err != nil {
+		return errors.WithStack(o.checkImmutability(blob, key, err))
+	}
+
+	// deleting the current version of an object only turns it into a previous version
+	if o.deleteAllVersions {
+		return o.deleteVersions(ctx, bucket, key)
+	}
+	return nil
+}
+
+func (o *ObjectStore) CreateSignedURL(bucket, key string, ttl time.Duration) (_ string, err error) {
+	ctx, end := startObjectStoreOperation("CreateSignedURL", attribute.String("bucket", bucket), attribute.String("key", key))
+	defer end(&err)
+	blob := o.blobGetter.getBlob(ctx, bucket, key)
+	if o.cpkInfo != nil {
+		o.log.Warnf("Object %s is encrypted with a customer-provided key, it can't be downloaded with a signed URL alone", key)
+	}
+	if o.keyWrapper != nil {
+		o.log.Warnf("Object %s may be encrypted client-side, the content downloaded with a signed URL isn't decrypted", key)
+	}
+	if o.compression != "" {
+		o.log.Warnf("Object %s may be compressed by the plugin, the content downloaded with a signed URL isn't decompressed", key)
+	}
 	return blob.GetSASURI(ttl, o.sharedKeyCredential)
 }
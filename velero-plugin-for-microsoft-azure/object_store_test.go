@@ -17,12 +17,24 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"hash/crc64"
 	"io"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	azcontainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -55,7 +67,7 @@ func TestObjectExists(t *testing.T) {
 		{
 			name:           "doesn't exist",
 			exists:         false,
-			errorResponse:  nil,
+			errorResponse:  &azcore.ResponseError{StatusCode: 404, ErrorCode: string(bloberror.BlobNotFound)},
 			expectedExists: false,
 		},
 		{
@@ -83,7 +95,7 @@ func TestObjectExists(t *testing.T) {
 			defer blob.AssertExpectations(t)
 			blobGetter.On("getBlob", bucket, key).Return(blob)
 
-			blob.On("Exists").Return(tc.exists, tc.errorResponse)
+			blob.On("GetProperties", mock.Anything).Return(azblobblob.GetPropertiesResponse{}, tc.errorResponse)
 
 			exists, err := o.ObjectExists(bucket, key)
 
@@ -98,11 +110,539 @@ func TestObjectExists(t *testing.T) {
 	}
 }
 
+func TestPutObject(t *testing.T) {
+	tests := []struct {
+		name              string
+		body              string
+		blockSize         int
+		uploadConcurrency int
+		putBlockError     error
+		expectedBlocks    []string
+		expectedError     string
+	}{
+		{
+			name:              "serial upload",
+			body:              "0123456789",
+			blockSize:         4,
+			uploadConcurrency: 1,
+			expectedBlocks:    []string{"0123", "4567", "89"},
+		},
+		{
+			name:              "parallel upload",
+			body:              "0123456789abcdef",
+			blockSize:         2,
+			uploadConcurrency: 3,
+			expectedBlocks:    []string{"01", "23", "45", "67", "89", "ab", "cd", "ef"},
+		},
+		{
+			name:              "object smaller than a block",
+			body:              "01",
+			blockSize:         4,
+			uploadConcurrency: 2,
+			expectedBlocks:    []string{"01"},
+		},
+		{
+			name:              "empty body",
+			body:              "",
+			blockSize:         4,
+			uploadConcurrency: 2,
+			expectedBlocks:    nil,
+		},
+		{
+			name:              "error putting block",
+			body:              "0123456789",
+			blockSize:         4,
+			uploadConcurrency: 2,
+			putBlockError:     errors.New("bad"),
+			expectedError:     "bad",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			blobGetter := new(mockBlobGetter)
+			defer blobGetter.AssertExpectations(t)
+
+			o := &ObjectStore{
+				log:               logrus.New(),
+				blobGetter:        blobGetter,
+				blockSize:         tc.blockSize,
+				uploadConcurrency: tc.uploadConcurrency,
+			}
+
+			blob := new(mockBlob)
+			blobGetter.On("getBlob", "b", "k").Return(blob)
+
+			// the blocks of a previous upload are only looked up for objects filling a block
+			if len(tc.body) >= tc.blockSize {
+				blob.On("GetUncommittedBlocks").Return([]string(nil), nil)
+			}
+
+			var (
+				blockIDs []string
+				offset   int64
+			)
+			for _, block := range tc.expectedBlocks {
+				blockID := getBlockID(offset, []byte(block))
+				blockIDs = append(blockIDs, blockID)
+				offset += int64(len(block))
+				blob.On("PutBlock", blockID, []byte(block), mock.Anything).Return(nil)
+			}
+			if tc.putBlockError != nil {
+				blob.On("PutBlock", mock.Anything, mock.Anything, mock.Anything).Return(tc.putBlockError)
+			} else {
+				blob.On("PutBlockList", blockIDs, mock.Anything).Return(nil)
+			}
+
+			err := o.PutObject("b", "k", bytes.NewReader([]byte(tc.body)))
+
+			if tc.expectedError != "" {
+				assert.ErrorContains(t, err, tc.expectedError)
+				blob.AssertNotCalled(t, "PutBlockList", mock.Anything, mock.Anything)
+				return
+			}
+			require.NoError(t, err)
+			blob.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPutObjectResume(t *testing.T) {
+	blobGetter := new(mockBlobGetter)
+	blob := new(mockBlob)
+	defer blob.AssertExpectations(t)
+	blobGetter.On("getBlob", "b", "k").Return(blob)
+
+	o := &ObjectStore{
+		log:               logrus.New(),
+		blobGetter:        blobGetter,
+		blockSize:         4,
+		uploadConcurrency: 2,
+	}
+
+	// the first two blocks were staged by an interrupted upload, as well as a block of other content
+	blockIDs := []string{getBlockID(0, []byte("0123")), getBlockID(4, []byte("4567")), getBlockID(8, []byte("89"))}
+	blob.On("GetUncommittedBlocks").Return([]string{blockIDs[0], blockIDs[1], getBlockID(8, []byte("xx"))}, nil)
+	blob.On("PutBlock", blockIDs[2], []byte("89"), mock.Anything).Return(nil).Once()
+	blob.On("PutBlockList", blockIDs, mock.Anything).Return(nil)
+
+	require.NoError(t, o.PutObject("b", "k", bytes.NewReader([]byte("0123456789"))))
+}
+
+func TestGetBlockID(t *testing.T) {
+	// block IDs must all have the same length and be at most 64 bytes before encoding
+	first := getBlockID(0, []byte("0123"))
+	last := getBlockID(1<<40, []byte("4"))
+	assert.Equal(t, len(first), len(last))
+	decoded, err := base64.StdEncoding.DecodeString(first)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(decoded), 64)
+
+	// the ID is derived from both the offset and the content
+	assert.Equal(t, first, getBlockID(0, []byte("0123")))
+	assert.NotEqual(t, first, getBlockID(4, []byte("0123")))
+	assert.NotEqual(t, first, getBlockID(0, []byte("0124")))
+}
+
+func TestPutObjectChecksums(t *testing.T) {
+	body := []byte("0123456789")
+	contentMD5 := md5.Sum(body)
+	checksumMismatch := &azcore.ResponseError{StatusCode: 400, ErrorCode: string(bloberror.MD5Mismatch)}
+
+	tests := []struct {
+		name              string
+		checksumAlgorithm string
+		putBlockErrors    []error
+		expectedOptions   *blockblob.StageBlockOptions
+		expectedCommit    *blockblob.CommitBlockListOptions
+		expectedError     string
+	}{
+		{
+			name:              "MD5",
+			checksumAlgorithm: checksumAlgorithmMD5,
+			expectedOptions:   &blockblob.StageBlockOptions{TransactionalValidation: azblobblob.TransferValidationTypeMD5(contentMD5[:])},
+			expectedCommit: &blockblob.CommitBlockListOptions{
+				HTTPHeaders: &azblobblob.HTTPHeaders{BlobContentMD5: contentMD5[:]},
+			},
+		},
+		{
+			name:              "CRC64",
+			checksumAlgorithm: checksumAlgorithmCRC64,
+			expectedOptions:   &blockblob.StageBlockOptions{TransactionalValidation: azblobblob.TransferValidationTypeCRC64(crc64.Checksum(body, crc64Table))},
+			expectedCommit: &blockblob.CommitBlockListOptions{
+				HTTPHeaders: &azblobblob.HTTPHeaders{BlobContentMD5: contentMD5[:]},
+			},
+		},
+		{
+			name:              "no checksum",
+			checksumAlgorithm: checksumAlgorithmNone,
+			expectedOptions:   &blockblob.StageBlockOptions{},
+			expectedCommit:    &blockblob.CommitBlockListOptions{},
+		},
+		{
+			name:              "checksum mismatch is retried",
+			checksumAlgorithm: checksumAlgorithmMD5,
+			putBlockErrors:    []error{checksumMismatch},
+			expectedOptions:   &blockblob.StageBlockOptions{TransactionalValidation: azblobblob.TransferValidationTypeMD5(contentMD5[:])},
+			expectedCommit: &blockblob.CommitBlockListOptions{
+				HTTPHeaders: &azblobblob.HTTPHeaders{BlobContentMD5: contentMD5[:]},
+			},
+		},
+		{
+			name:              "persistent checksum mismatch fails",
+			checksumAlgorithm: checksumAlgorithmMD5,
+			putBlockErrors:    []error{checksumMismatch, checksumMismatch, checksumMismatch},
+			expectedOptions:   &blockblob.StageBlockOptions{TransactionalValidation: azblobblob.TransferValidationTypeMD5(contentMD5[:])},
+			expectedError:     "was rejected 3 times",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			blobGetter := new(mockBlobGetter)
+			blob := new(mockBlob)
+			blobGetter.On("getBlob", "b", "k").Return(blob)
+
+			o := &ObjectStore{
+				log:               logrus.New(),
+				blobGetter:        blobGetter,
+				blockSize:         len(body),
+				checksumAlgorithm: tc.checksumAlgorithm,
+			}
+
+			blockID := getBlockID(0, body)
+			blob.On("GetUncommittedBlocks").Return([]string(nil), nil)
+			for _, err := range tc.putBlockErrors {
+				blob.On("PutBlock", blockID, body, tc.expectedOptions).Return(err).Once()
+			}
+			if tc.expectedError == "" {
+				blob.On("PutBlock", blockID, body, tc.expectedOptions).Return(nil).Once()
+				blob.On("PutBlockList", []string{blockID}, tc.expectedCommit).Return(nil)
+			}
+
+			err := o.PutObject("b", "k", bytes.NewReader(body))
+
+			if tc.expectedError != "" {
+				assert.ErrorContains(t, err, tc.expectedError)
+			} else {
+				require.NoError(t, err)
+			}
+			blob.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetObject(t *testing.T) {
+	content := []byte("some backup content")
+	contentMD5 := md5.Sum(content)
+	etag := azcore.ETag("etag")
+	ifMatch := &azblob.DownloadStreamOptions{
+		AccessConditions: &azblobblob.AccessConditions{
+			ModifiedAccessConditions: &azblobblob.ModifiedAccessConditions{IfMatch: &etag},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		verifyDownloads bool
+		storedMD5       []byte
+		body            []byte
+		expectedOptions *azblob.DownloadStreamOptions
+		expectedError   bool
+	}{
+		{
+			name:            "verification disabled",
+			body:            content,
+			expectedOptions: &azblob.DownloadStreamOptions{},
+		},
+		{
+			name:            "verification enabled",
+			verifyDownloads: true,
+			storedMD5:       contentMD5[:],
+			body:            content,
+			expectedOptions: ifMatch,
+		},
+		{
+			name:            "verification enabled, corrupted content",
+			verifyDownloads: true,
+			storedMD5:       contentMD5[:],
+			body:            []byte("some backup c0ntent"),
+			expectedOptions: ifMatch,
+			expectedError:   true,
+		},
+		{
+			name:            "verification enabled, no stored MD5",
+			verifyDownloads: true,
+			body:            content,
+			expectedOptions: ifMatch,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			blobGetter := new(mockBlobGetter)
+			blob := new(mockBlob)
+			defer blob.AssertExpectations(t)
+			blobGetter.On("getBlob", "b", "k").Return(blob)
+
+			o := &ObjectStore{
+				log:             logrus.New(),
+				blobGetter:      blobGetter,
+				verifyDownloads: tc.verifyDownloads,
+			}
+
+			if tc.verifyDownloads {
+				blob.On("GetProperties", mock.Anything).Return(azblobblob.GetPropertiesResponse{ContentMD5: tc.storedMD5, ETag: &etag}, nil)
+			}
+			blob.On("Get", tc.expectedOptions).Return(io.NopCloser(bytes.NewReader(tc.body)), map[string]*string(nil), nil)
+
+			body, err := o.GetObject("b", "k")
+			require.NoError(t, err)
+			data, err := io.ReadAll(body)
+			if tc.expectedError {
+				var mismatch *ChecksumMismatchError
+				assert.ErrorAs(t, err, &mismatch)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.body, data)
+		})
+	}
+}
+
+func TestGetObjectArchived(t *testing.T) {
+	archived := &azcore.ResponseError{StatusCode: 409, ErrorCode: string(bloberror.BlobArchived)}
+
+	// detected from the download
+	blobGetter := new(mockBlobGetter)
+	blob := new(mockBlob)
+	blobGetter.On("getBlob", "b", "k").Return(blob)
+	blob.On("Get", mock.Anything).Return(io.NopCloser(nil), map[string]*string(nil), errors.WithStack(archived))
+	blob.On("GetProperties", mock.Anything).Return(azblobblob.GetPropertiesResponse{AccessTier: to.Ptr(string(azblobblob.AccessTierArchive))}, nil)
+
+	o := &ObjectStore{log: logrus.New(), blobGetter: blobGetter}
+	_, err := o.GetObject("b", "k")
+	var archivedErr *ArchivedObjectError
+	require.ErrorAs(t, err, &archivedErr)
+	assert.Equal(t, "k", archivedErr.Key)
+
+	// detected from the properties
+	blob = new(mockBlob)
+	blobGetter = new(mockBlobGetter)
+	blobGetter.On("getBlob", "b", "k").Return(blob)
+	blob.On("GetProperties", mock.Anything).Return(azblobblob.GetPropertiesResponse{AccessTier: to.Ptr(string(azblobblob.AccessTierArchive))}, nil)
+
+	o = &ObjectStore{log: logrus.New(), blobGetter: blobGetter, verifyDownloads: true}
+	_, err = o.GetObject("b", "k")
+	require.ErrorAs(t, err, &archivedErr)
+	blob.AssertNotCalled(t, "Get", mock.Anything)
+}
+
+func TestGetObjectParallel(t *testing.T) {
+	content := []byte("0123456789")
+	contentMD5 := md5.Sum(content)
+	size := int64(len(content))
+
+	blobGetter := new(mockBlobGetter)
+	blob := new(mockBlob)
+	defer blob.AssertExpectations(t)
+	blobGetter.On("getBlob", "b", "k").Return(blob)
+
+	o := &ObjectStore{
+		log:                       logrus.New(),
+		blobGetter:                blobGetter,
+		verifyDownloads:           true,
+		downloadConcurrency:       2,
+		downloadRangeSize:         4,
+		parallelDownloadThreshold: 8,
+	}
+
+	blob.On("GetProperties", mock.Anything).Return(azblobblob.GetPropertiesResponse{ContentLength: &size, ContentMD5: contentMD5[:]}, nil)
+	blob.On("Get", matchRange(0, 4)).Return(io.NopCloser(bytes.NewReader(content[0:4])), map[string]*string(nil), nil)
+	blob.On("Get", matchRange(4, 4)).Return(io.NopCloser(bytes.NewReader(content[4:8])), map[string]*string(nil), nil)
+	blob.On("Get", matchRange(8, 2)).Return(io.NopCloser(bytes.NewReader(content[8:10])), map[string]*string(nil), nil)
+
+	body, err := o.GetObject("b", "k")
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	require.NoError(t, body.Close())
+}
+
+func TestGetChecksumAlgorithm(t *testing.T) {
+	config := map[string]string{}
+	// not specified, no checksum is sent
+	algorithm, err := getChecksumAlgorithm(config)
+	require.NoError(t, err)
+	assert.Equal(t, checksumAlgorithmNone, algorithm)
+
+	// case insensitive
+	config[checksumAlgorithmConfigKey] = "crc64"
+	algorithm, err = getChecksumAlgorithm(config)
+	require.NoError(t, err)
+	assert.Equal(t, checksumAlgorithmCRC64, algorithm)
+
+	// invalid value specified
+	config[checksumAlgorithmConfigKey] = "sha1"
+	_, err = getChecksumAlgorithm(config)
+	assert.Error(t, err)
+}
+
+func TestGetVerifyDownloads(t *testing.T) {
+	config := map[string]string{}
+	// not specified
+	verifyDownloads, err := getVerifyDownloads(config, checksumAlgorithmNone)
+	require.NoError(t, err)
+	assert.False(t, verifyDownloads)
+
+	config[verifyDownloadsConfigKey] = "true"
+	verifyDownloads, err = getVerifyDownloads(config, checksumAlgorithmCRC64)
+	require.NoError(t, err)
+	assert.True(t, verifyDownloads)
+
+	// the objects uploaded without checksum can't be verified
+	_, err = getVerifyDownloads(config, checksumAlgorithmNone)
+	assert.ErrorContains(t, err, `config key "verifyDownloads" requires config key "checksumAlgorithm" to be set to MD5 or CRC64`)
+
+	// invalid value specified
+	config[verifyDownloadsConfigKey] = "invalid"
+	_, err = getVerifyDownloads(config, checksumAlgorithmMD5)
+	assert.Error(t, err)
+}
+
+func TestGetUploadConcurrency(t *testing.T) {
+	config := map[string]string{}
+	// not specified
+	concurrency, err := getUploadConcurrency(config)
+	require.NoError(t, err)
+	assert.Equal(t, defaultUploadConcurrency, concurrency)
+
+	// invalid value specified
+	config[uploadConcurrencyConfigKey] = "invalid"
+	_, err = getUploadConcurrency(config)
+	assert.Error(t, err)
+
+	// value < 1 specified
+	config[uploadConcurrencyConfigKey] = "0"
+	_, err = getUploadConcurrency(config)
+	assert.Error(t, err)
+
+	// value > max specified
+	config[uploadConcurrencyConfigKey] = "1000"
+	_, err = getUploadConcurrency(config)
+	assert.Error(t, err)
+
+	// valid value specified
+	config[uploadConcurrencyConfigKey] = "8"
+	concurrency, err = getUploadConcurrency(config)
+	require.NoError(t, err)
+	assert.Equal(t, 8, concurrency)
+}
+
+type mockContainerGetter struct {
+	mock.Mock
+}
+
+func (m *mockContainerGetter) getContainer(_ context.Context, bucket string) container {
+	args := m.Called(bucket)
+	return args.Get(0).(container)
+}
+
+// fakeContainer serves the blob items of a flat listing and the prefixes of a hierarchical
+// listing in pages of MaxResults items, and records the batches of deleted blobs and versions
+type fakeContainer struct {
+	items    []*azcontainer.BlobItem
+	prefixes []string
+	// the errors of the deletion of some blobs, by name, and of whole batches, by index
+	deleteErrors map[string]error
+	batchErrors  map[int]error
+	batches      [][]string
+	versions     [][]blobVersion
+	// the number of pages fetched
+	pages int
+}
+
+// fakePage returns the range of the page starting at the marker, which is the index of its first item
+func (c *fakeContainer) fakePage(total int, marker *string, maxResults *int32) (int, int, *string) {
+	c.pages++
+	start := 0
+	if marker != nil {
+		start, _ = strconv.Atoi(*marker)
+	}
+	end := total
+	if maxResults != nil && start+int(*maxResults) < total {
+		end = start + int(*maxResults)
+	}
+	if end == total {
+		return start, end, nil
+	}
+	return start, end, to.Ptr(strconv.Itoa(end))
+}
+
+func (c *fakeContainer) ListBlobs(params *azcontainer.ListBlobsFlatOptions) *runtime.Pager[azcontainer.ListBlobsFlatResponse] {
+	return runtime.NewPager(runtime.PagingHandler[azcontainer.ListBlobsFlatResponse]{
+		More: func(page azcontainer.ListBlobsFlatResponse) bool { return page.NextMarker != nil },
+		Fetcher: func(_ context.Context, previous *azcontainer.ListBlobsFlatResponse) (azcontainer.ListBlobsFlatResponse, error) {
+			marker := params.Marker
+			if previous != nil {
+				marker = previous.NextMarker
+			}
+			var res azcontainer.ListBlobsFlatResponse
+			start, end, next := c.fakePage(len(c.items), marker, params.MaxResults)
+			res.Segment = &azcontainer.BlobFlatListSegment{BlobItems: c.items[start:end]}
+			res.NextMarker = next
+			return res, nil
+		},
+	})
+}
+
+func (c *fakeContainer) ListBlobsHierarchy(delimiter string, listOptions *azcontainer.ListBlobsHierarchyOptions) *runtime.Pager[azcontainer.ListBlobsHierarchyResponse] {
+	return runtime.NewPager(runtime.PagingHandler[azcontainer.ListBlobsHierarchyResponse]{
+		More: func(page azcontainer.ListBlobsHierarchyResponse) bool { return page.NextMarker != nil },
+		Fetcher: func(_ context.Context, previous *azcontainer.ListBlobsHierarchyResponse) (azcontainer.ListBlobsHierarchyResponse, error) {
+			marker := listOptions.Marker
+			if previous != nil {
+				marker = previous.NextMarker
+			}
+			var res azcontainer.ListBlobsHierarchyResponse
+			start, end, next := c.fakePage(len(c.prefixes), marker, listOptions.MaxResults)
+			res.Segment = &azcontainer.BlobHierarchyListSegment{}
+			for _, prefix := range c.prefixes[start:end] {
+				res.Segment.BlobPrefixes = append(res.Segment.BlobPrefixes, &azcontainer.BlobPrefix{Name: to.Ptr(prefix)})
+			}
+			res.NextMarker = next
+			return res, nil
+		},
+	})
+}
+
+func (c *fakeContainer) DeleteBlobs(names []string) ([]error, error) {
+	c.batches = append(c.batches, names)
+	if err := c.batchErrors[len(c.batches)-1]; err != nil {
+		return nil, err
+	}
+	errs := make([]error, len(names))
+	for i, name := range names {
+		errs[i] = c.deleteErrors[name]
+	}
+	return errs, nil
+}
+
+func (c *fakeContainer) DeleteVersions(versions []blobVersion) ([]error, error) {
+	c.versions = append(c.versions, versions)
+	errs := make([]error, len(versions))
+	for i, version := range versions {
+		errs[i] = c.deleteErrors[version.Name+"@"+version.VersionID]
+	}
+	return errs, nil
+}
+
 type mockBlobGetter struct {
 	mock.Mock
 }
 
-func (m *mockBlobGetter) getBlob(bucket string, key string) blob {
+func (m *mockBlobGetter) getBlob(_ context.Context, bucket string, key string) blob {
 	args := m.Called(bucket, key)
 	return args.Get(0).(blob)
 }
@@ -120,14 +660,24 @@ func (m *mockBlob) PutBlockList(blocks []string, options *blockblob.CommitBlockL
 	return args.Error(0)
 }
 
-func (m *mockBlob) Exists() (bool, error) {
+func (m *mockBlob) GetUncommittedBlocks() ([]string, error) {
 	args := m.Called()
-	return args.Bool(0), args.Error(1)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *mockBlob) GetProperties(options *azblobblob.GetPropertiesOptions) (azblobblob.GetPropertiesResponse, error) {
+	args := m.Called(options)
+	return args.Get(0).(azblobblob.GetPropertiesResponse), args.Error(1)
 }
 
-func (m *mockBlob) Get(options *azblob.DownloadStreamOptions) (io.ReadCloser, error) {
+func (m *mockBlob) Get(options *azblob.DownloadStreamOptions) (io.ReadCloser, map[string]*string, error) {
 	args := m.Called(options)
-	return args.Get(0).(io.ReadCloser), args.Error(1)
+	return args.Get(0).(io.ReadCloser), args.Get(1).(map[string]*string), args.Error(2)
+}
+
+func (m *mockBlob) SetTier(tier azblobblob.AccessTier, options *azblobblob.SetTierOptions) error {
+	args := m.Called(tier, options)
+	return args.Error(0)
 }
 
 func (m *mockBlob) Delete(options *azblob.DeleteBlobOptions) error {
@@ -135,6 +685,21 @@ func (m *mockBlob) Delete(options *azblob.DeleteBlobOptions) error {
 	return args.Error(0)
 }
 
+func (m *mockBlob) DeleteVersion(versionID string) error {
+	args := m.Called(versionID)
+	return args.Error(0)
+}
+
+func (m *mockBlob) Undelete() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *mockBlob) PromoteVersion(versionID string) error {
+	args := m.Called(versionID)
+	return args.Error(0)
+}
+
 func (m *mockBlob) GetSASURI(ttl time.Duration, sharedKeyCredential *azblob.SharedKeyCredential) (string, error) {
 	args := m.Called(ttl, sharedKeyCredential)
 	return args.String(0), args.Error(1)
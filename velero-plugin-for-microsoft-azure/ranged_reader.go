@@ -0,0 +1,196 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// how many times a range is downloaded before giving up on the whole object
+const maxRangeAttempts = 3
+
+// errRangedReaderClosed is returned by the reads of a download after it is closed, so that a
+// truncated read isn't mistaken for the end of the object
+var errRangedReaderClosed = errors.New("the download is already closed")
+
+type rangeResult struct {
+	data []byte
+	err  error
+}
+
+// rangedReadCloser downloads an object as consecutive byte ranges fetched in parallel and
+// returns their content in order. A range that fails to download is fetched again on its
+// own, without restarting the whole download.
+type rangedReadCloser struct {
+	log     logrus.FieldLogger
+	blob    blob
+	key     string
+	options azblob.DownloadStreamOptions
+	// the delays between the attempts of a range
+	retry *retryPolicy
+
+	// pending holds the ranges in the order they must be read. A range is only fetched once
+	// it is queued, so the capacity of the queue bounds the number of ranges in memory.
+	pending   chan chan rangeResult
+	current   *bytes.Reader
+	err       error
+	done      chan struct{}
+	closeOnce sync.Once
+	// cancels the requests of the blob, i.e. the ranges being downloaded
+	cancel context.CancelFunc
+}
+
+// newRangedReadCloser returns a reader of the object downloaded with the requests of the blob,
+// which must be cancelled by cancel when the reader is closed. A failed range is downloaded
+// again after the delays of the retry policy.
+func newRangedReadCloser(log logrus.FieldLogger, blob blob, key string, size, rangeSize int64, concurrency int, options *azblob.DownloadStreamOptions, retry *retryPolicy, cancel context.CancelFunc) *rangedReadCloser {
+	r := &rangedReadCloser{
+		log:   log,
+		blob:  blob,
+		key:   key,
+		retry: retry,
+		// the range being read counts towards the concurrency as well
+		pending: make(chan chan rangeResult, concurrency-1),
+		current: bytes.NewReader(nil),
+		done:    make(chan struct{}),
+		cancel:  cancel,
+	}
+	if options != nil {
+		r.options = *options
+	}
+
+	go r.fetchRanges(size, rangeSize)
+	return r
+}
+
+func (r *rangedReadCloser) fetchRanges(size, rangeSize int64) {
+	defer close(r.pending)
+
+	for offset := int64(0); offset < size; offset += rangeSize {
+		count := rangeSize
+		if offset+count > size {
+			count = size - offset
+		}
+
+		result := make(chan rangeResult, 1)
+		select {
+		case r.pending <- result:
+		case <-r.done:
+			return
+		}
+
+		go func(offset, count int64) {
+			result <- r.fetchRange(offset, count)
+		}(offset, count)
+	}
+}
+
+func (r *rangedReadCloser) fetchRange(offset, count int64) rangeResult {
+	var err error
+	for attempt := 1; attempt <= maxRangeAttempts; attempt++ {
+		var data []byte
+		if data, err = r.downloadRange(offset, count); err == nil {
+			return rangeResult{data: data}
+		}
+		// the object changed since the download started, downloading again won't help
+		if bloberror.HasCode(err, bloberror.ConditionNotMet) {
+			break
+		}
+
+		select {
+		case <-r.done:
+			return rangeResult{err: err}
+		default:
+		}
+		r.log.WithError(err).Warnf("Error downloading range %d-%d of %s (attempt %d of %d)", offset, offset+count-1, r.key, attempt, maxRangeAttempts)
+		if attempt == maxRangeAttempts {
+			break
+		}
+
+		timer := time.NewTimer(r.retry.retryDelay(attempt))
+		select {
+		case <-r.done:
+			timer.Stop()
+			return rangeResult{err: err}
+		case <-timer.C:
+		}
+	}
+
+	return rangeResult{err: errors.Wrapf(err, "error downloading range %d-%d of %s", offset, offset+count-1, r.key)}
+}
+
+func (r *rangedReadCloser) downloadRange(offset, count int64) ([]byte, error) {
+	options := r.options
+	options.Range = azblob.HTTPRange{Offset: offset, Count: count}
+
+	body, _, err := r.blob.Get(&options)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data := make([]byte, count)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return nil, errors.Wrap(err, "error reading range")
+	}
+	return data, nil
+}
+
+func (r *rangedReadCloser) Read(p []byte) (int, error) {
+	select {
+	case <-r.done:
+		return 0, errRangedReaderClosed
+	default:
+	}
+
+	for r.current.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		result, ok := <-r.pending
+		if !ok {
+			return 0, io.EOF
+		}
+		res := <-result
+		if res.err != nil {
+			r.err = res.err
+			return 0, r.err
+		}
+		r.current = bytes.NewReader(res.data)
+	}
+
+	return r.current.Read(p)
+}
+
+// Close stops queueing ranges and cancels the downloads of the ranges in progress
+func (r *rangedReadCloser) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		r.cancel()
+	})
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func matchRange(offset, count int64) interface{} {
+	return mock.MatchedBy(func(options *azblob.DownloadStreamOptions) bool {
+		return options.Range.Offset == offset && options.Range.Count == count
+	})
+}
+
+func TestRangedReadCloser(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+
+	tests := []struct {
+		name          string
+		rangeSize     int64
+		concurrency   int
+		failures      map[int64]int
+		expectedError string
+	}{
+		{
+			name:        "ranges are reassembled in order",
+			rangeSize:   3,
+			concurrency: 4,
+		},
+		{
+			name:        "range size larger than the object",
+			rangeSize:   100,
+			concurrency: 2,
+		},
+		{
+			name:        "failed range is retried",
+			rangeSize:   5,
+			concurrency: 2,
+			failures:    map[int64]int{5: 2},
+		},
+		{
+			name:          "range failing too many times fails the download",
+			rangeSize:     5,
+			concurrency:   2,
+			failures:      map[int64]int{10: maxRangeAttempts},
+			expectedError: "error downloading range 10-14 of k: bad",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			blob := new(mockBlob)
+
+			size := int64(len(content))
+			for offset := int64(0); offset < size; offset += tc.rangeSize {
+				count := min(tc.rangeSize, size-offset)
+				if failures := tc.failures[offset]; failures > 0 {
+					blob.On("Get", matchRange(offset, count)).Return(io.NopCloser(bytes.NewReader(nil)), map[string]*string(nil), errors.New("bad")).Times(failures)
+				}
+				blob.On("Get", matchRange(offset, count)).Return(io.NopCloser(bytes.NewReader(content[offset:offset+count])), map[string]*string(nil), nil).Maybe()
+			}
+
+			r := newRangedReadCloser(logrus.New(), blob, "k", size, tc.rangeSize, tc.concurrency, nil, &retryPolicy{delay: time.Millisecond}, func() {})
+			defer r.Close()
+
+			data, err := io.ReadAll(r)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, content, data)
+		})
+	}
+}
+
+func TestRangedReadCloserClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	blob := new(mockBlob)
+	// the downloads only complete when they're cancelled
+	blob.On("Get", mock.Anything).Run(func(mock.Arguments) { <-ctx.Done() }).Return(io.NopCloser(bytes.NewReader(nil)), map[string]*string(nil), context.Canceled)
+
+	r := newRangedReadCloser(logrus.New(), blob, "k", 100, 10, 4, nil, nil, cancel)
+	require.NoError(t, r.Close())
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	// a read after the close isn't mistaken for the end of the object
+	_, err := r.Read(make([]byte, 10))
+	assert.ErrorIs(t, err, errRangedReaderClosed)
+}
+
+func TestRangedReadCloserCloseWhileWaiting(t *testing.T) {
+	blob := new(mockBlob)
+	failed := make(chan struct{})
+	blob.On("Get", mock.Anything).Run(func(mock.Arguments) { close(failed) }).Return(io.NopCloser(bytes.NewReader(nil)), map[string]*string(nil), errors.New("bad")).Once()
+
+	// the range isn't downloaded again before the delay
+	r := newRangedReadCloser(logrus.New(), blob, "k", 10, 10, 1, nil, &retryPolicy{delay: time.Hour}, func() {})
+	read := make(chan error)
+	go func() {
+		_, err := r.Read(make([]byte, 10))
+		read <- err
+	}()
+
+	// closing the reader stops waiting for the delay
+	<-failed
+	require.NoError(t, r.Close())
+	select {
+	case err := <-read:
+		assert.EqualError(t, err, "bad")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the range is still waiting to be downloaded again")
+	}
+	blob.AssertExpectations(t)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+const (
+	vslConfigKeyReadRequestsPerSecond  = "readRequestsPerSecond"
+	vslConfigKeyReadBurst              = "readBurst"
+	vslConfigKeyWriteRequestsPerSecond = "writeRequestsPerSecond"
+	vslConfigKeyWriteBurst             = "writeBurst"
+
+	rateLimitRead  = "read"
+	rateLimitWrite = "write"
+)
+
+// armRateLimiter limits the rate of the requests of the ARM clients it's added to, with
+// token buckets for reads and writes, since Azure throttles them separately
+// ref. https://learn.microsoft.com/en-us/azure/azure-resource-manager/management/request-limits-and-throttling
+type armRateLimiter struct {
+	// nil when the requests of the kind aren't limited
+	read  *rate.Limiter
+	write *rate.Limiter
+}
+
+func getARMRateLimiter(config map[string]string) (*armRateLimiter, error) {
+	var (
+		l   armRateLimiter
+		err error
+	)
+	if l.read, err = getRateLimiter(config, vslConfigKeyReadRequestsPerSecond, vslConfigKeyReadBurst); err != nil {
+		return nil, err
+	}
+	if l.write, err = getRateLimiter(config, vslConfigKeyWriteRequestsPerSecond, vslConfigKeyWriteBurst); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func getRateLimiter(config map[string]string, rateKey, burstKey string) (*rate.Limiter, error) {
+	val := config[rateKey]
+	if val == "" {
+		if config[burstKey] != "" {
+			return nil, errors.Errorf("config key %q requires config key %q", burstKey, rateKey)
+		}
+		return nil, nil
+	}
+
+	perSecond, err := strconv.ParseFloat(val, 64)
+	if err != nil || perSecond <= 0 || math.IsInf(perSecond, 0) {
+		return nil, errors.Errorf("unable to parse value %q for config key %q (expected a positive number)", val, rateKey)
+	}
+	// by default, as many requests as the rate allows in a second can be sent at once
+	burst, err := getIntConfig(config, burstKey, int64(math.Max(1, perSecond)), 1, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), int(burst)), nil
+}
+
+// Do waits for the rate limiter of the kind of the request. The limiter is added to the
+// policies of every try, so that retries and polls are limited as well.
+func (l *armRateLimiter) Do(req *policy.Request) (*http.Response, error) {
+	limiter, kind := l.read, rateLimitRead
+	if method := req.Raw().Method; method != http.MethodGet && method != http.MethodHead {
+		limiter, kind = l.write, rateLimitWrite
+	}
+
+	if limiter != nil {
+		start := time.Now()
+		if err := limiter.Wait(req.Raw().Context()); err != nil {
+			return nil, errors.Wrapf(err, "error waiting for the rate limit of ARM %s requests", kind)
+		}
+		armRateLimitWaitSeconds.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	}
+	return req.Next()
+}
@@ -0,0 +1,99 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestGetARMRateLimiter(t *testing.T) {
+	l, err := getARMRateLimiter(map[string]string{})
+	require.NoError(t, err)
+	assert.Nil(t, l.read)
+	assert.Nil(t, l.write)
+
+	l, err = getARMRateLimiter(map[string]string{
+		vslConfigKeyReadRequestsPerSecond:  "20",
+		vslConfigKeyWriteRequestsPerSecond: "0.5",
+		vslConfigKeyWriteBurst:             "5",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, rate.Limit(20), l.read.Limit())
+	assert.Equal(t, 20, l.read.Burst())
+	assert.Equal(t, rate.Limit(0.5), l.write.Limit())
+	assert.Equal(t, 5, l.write.Burst())
+
+	for _, config := range []map[string]string{
+		{vslConfigKeyReadRequestsPerSecond: "0"},
+		{vslConfigKeyReadRequestsPerSecond: "fast"},
+		{vslConfigKeyWriteRequestsPerSecond: "1", vslConfigKeyWriteBurst: "0"},
+		{vslConfigKeyWriteBurst: "5"},
+	} {
+		_, err := getARMRateLimiter(config)
+		assert.Error(t, err, config)
+	}
+}
+
+func sampleCount(t *testing.T, kind string) uint64 {
+	var m dto.Metric
+	require.NoError(t, armRateLimitWaitSeconds.WithLabelValues(kind).(prometheus.Histogram).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestARMRateLimiter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	l := &armRateLimiter{write: rate.NewLimiter(rate.Every(100*time.Millisecond), 1)}
+	pipeline := azruntime.NewPipeline("test", "v1", azruntime.PipelineOptions{}, &policy.ClientOptions{PerRetryPolicies: []policy.Policy{l}})
+	send := func(ctx context.Context, method string) error {
+		req, err := azruntime.NewRequest(ctx, method, server.URL)
+		require.NoError(t, err)
+		_, err = pipeline.Do(req)
+		return err
+	}
+
+	writes := sampleCount(t, rateLimitWrite)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, send(context.Background(), http.MethodPut))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	assert.Equal(t, writes+3, sampleCount(t, rateLimitWrite))
+
+	// reads aren't limited
+	reads := sampleCount(t, rateLimitRead)
+	require.NoError(t, send(context.Background(), http.MethodGet))
+	assert.Equal(t, reads, sampleCount(t, rateLimitRead))
+
+	// waiting stops with the context of the request
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, send(ctx, http.MethodDelete))
+}
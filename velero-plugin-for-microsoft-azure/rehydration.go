@@ -0,0 +1,147 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	rehydrateConfigKey         = "rehydrateArchivedObjects"
+	rehydrateTierConfigKey     = "rehydrateTier"
+	rehydratePriorityConfigKey = "rehydratePriority"
+)
+
+// rehydration holds how archived objects are brought back to an online tier
+type rehydration struct {
+	// whether reading an archived object starts its rehydration
+	enabled  bool
+	tier     azblobblob.AccessTier
+	priority azblobblob.RehydratePriority
+}
+
+func getRehydration(config map[string]string) (rehydration, error) {
+	r := rehydration{
+		tier:     azblobblob.AccessTierHot,
+		priority: azblobblob.RehydratePriorityStandard,
+	}
+
+	if val := config[rehydrateConfigKey]; val != "" {
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return r, errors.Wrapf(err, "unable to parse value %q for config key %q (expected a boolean value)", val, rehydrateConfigKey)
+		}
+		r.enabled = enabled
+	}
+
+	if val := config[rehydrateTierConfigKey]; val != "" {
+		tier, err := parseAccessTier(val)
+		if err != nil || tier == azblobblob.AccessTierArchive {
+			return r, errors.Errorf("unsupported value %q for config key %q (expected one of %s, %s or %s)", val, rehydrateTierConfigKey,
+				azblobblob.AccessTierHot, azblobblob.AccessTierCool, azblobblob.AccessTierCold)
+		}
+		r.tier = tier
+	}
+
+	if val := config[rehydratePriorityConfigKey]; val != "" {
+		switch {
+		case strings.EqualFold(val, string(azblobblob.RehydratePriorityStandard)):
+			r.priority = azblobblob.RehydratePriorityStandard
+		case strings.EqualFold(val, string(azblobblob.RehydratePriorityHigh)):
+			r.priority = azblobblob.RehydratePriorityHigh
+		default:
+			return r, errors.Errorf("unsupported value %q for config key %q (expected %s or %s)", val, rehydratePriorityConfigKey,
+				azblobblob.RehydratePriorityStandard, azblobblob.RehydratePriorityHigh)
+		}
+	}
+
+	return r, nil
+}
+
+func isArchived(accessTier *string) bool {
+	return accessTier != nil && *accessTier == string(azblobblob.AccessTierArchive)
+}
+
+// archivedObjectError reports the rehydration progress of an archived object and, if
+// enabled, starts its rehydration. The properties of the object are fetched if nil.
+func (o *ObjectStore) archivedObjectError(blob blob, key string, props *azblobblob.GetPropertiesResponse) error {
+	if props == nil {
+		res, err := blob.GetProperties(&azblobblob.GetPropertiesOptions{CPKInfo: o.cpkInfo})
+		if err != nil {
+			return errors.Wrapf(err, "error getting the properties of archived object %s", key)
+		}
+		props = &res
+	}
+
+	if props.ArchiveStatus != nil && *props.ArchiveStatus != "" {
+		o.log.Infof("Rehydration of object %s is in progress (%s)", key, *props.ArchiveStatus)
+		return &ArchivedObjectError{Key: key, RehydrationStatus: *props.ArchiveStatus}
+	}
+	if !o.rehydration.enabled {
+		return &ArchivedObjectError{Key: key}
+	}
+
+	if err := o.startRehydration(blob, key); err != nil {
+		return err
+	}
+	return &ArchivedObjectError{Key: key, RehydrationStatus: "rehydrate-pending-to-" + strings.ToLower(string(o.rehydration.tier))}
+}
+
+func (o *ObjectStore) startRehydration(blob blob, key string) error {
+	o.log.Infof("Starting rehydration of object %s to the %s tier with %s priority", key, o.rehydration.tier, o.rehydration.priority)
+	if err := blob.SetTier(o.rehydration.tier, &azblobblob.SetTierOptions{RehydratePriority: &o.rehydration.priority}); err != nil {
+		return errors.Wrapf(err, "error starting rehydration of object %s", key)
+	}
+	return nil
+}
+
+// RehydratePrefix starts the rehydration of all the archived objects under the prefix, so
+// that a backup can be pre-warmed before it is restored.
+func (o *ObjectStore) RehydratePrefix(bucket, prefix string) (err error) {
+	ctx, end := startObjectStoreOperation("RehydratePrefix", attribute.String("bucket", bucket), attribute.String("prefix", prefix))
+	defer end(&err)
+
+	var started, inProgress int
+	it := o.iterateObjects(ctx, bucket, prefix)
+	for it.Next() {
+		item := it.Item()
+		if item.Properties == nil || item.Properties.AccessTier == nil || *item.Properties.AccessTier != azblobblob.AccessTierArchive {
+			continue
+		}
+		if item.Properties.ArchiveStatus != nil {
+			o.log.Debugf("Rehydration of object %s is in progress (%s)", *item.Name, *item.Properties.ArchiveStatus)
+			inProgress++
+			continue
+		}
+
+		if err := o.startRehydration(o.blobGetter.getBlob(ctx, bucket, *item.Name), *item.Name); err != nil {
+			return err
+		}
+		started++
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	o.log.Infof("Started rehydration of %d objects under prefix %q, %d objects were already being rehydrated", started, prefix, inProgress)
+	return nil
+}
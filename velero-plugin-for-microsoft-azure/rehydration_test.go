@@ -0,0 +1,148 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	azcontainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRehydration(t *testing.T) {
+	// defaults
+	r, err := getRehydration(map[string]string{})
+	require.NoError(t, err)
+	assert.False(t, r.enabled)
+	assert.Equal(t, azblobblob.AccessTierHot, r.tier)
+	assert.Equal(t, azblobblob.RehydratePriorityStandard, r.priority)
+
+	// valid values
+	r, err = getRehydration(map[string]string{
+		rehydrateConfigKey:         "true",
+		rehydrateTierConfigKey:     "cool",
+		rehydratePriorityConfigKey: "high",
+	})
+	require.NoError(t, err)
+	assert.True(t, r.enabled)
+	assert.Equal(t, azblobblob.AccessTierCool, r.tier)
+	assert.Equal(t, azblobblob.RehydratePriorityHigh, r.priority)
+
+	// invalid values
+	_, err = getRehydration(map[string]string{rehydrateConfigKey: "maybe"})
+	assert.Error(t, err)
+	_, err = getRehydration(map[string]string{rehydrateTierConfigKey: "Archive"})
+	assert.Error(t, err)
+	_, err = getRehydration(map[string]string{rehydratePriorityConfigKey: "Urgent"})
+	assert.Error(t, err)
+}
+
+func TestArchivedObjectError(t *testing.T) {
+	archived := to.Ptr(string(azblobblob.AccessTierArchive))
+
+	tests := []struct {
+		name           string
+		enabled        bool
+		archiveStatus  *string
+		expectSetTier  bool
+		expectedStatus string
+	}{
+		{
+			name: "rehydration disabled",
+		},
+		{
+			name:           "rehydration enabled",
+			enabled:        true,
+			expectSetTier:  true,
+			expectedStatus: "rehydrate-pending-to-hot",
+		},
+		{
+			name:           "rehydration in progress",
+			enabled:        true,
+			archiveStatus:  to.Ptr(string(azblobblob.ArchiveStatusRehydratePendingToHot)),
+			expectedStatus: "rehydrate-pending-to-hot",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			blobGetter := new(mockBlobGetter)
+			blob := new(mockBlob)
+			defer blob.AssertExpectations(t)
+			blobGetter.On("getBlob", "b", "k").Return(blob)
+
+			o := &ObjectStore{
+				log:             logrus.New(),
+				blobGetter:      blobGetter,
+				verifyDownloads: true,
+				rehydration: rehydration{
+					enabled:  tc.enabled,
+					tier:     azblobblob.AccessTierHot,
+					priority: azblobblob.RehydratePriorityHigh,
+				},
+			}
+
+			blob.On("GetProperties", mock.Anything).Return(azblobblob.GetPropertiesResponse{AccessTier: archived, ArchiveStatus: tc.archiveStatus}, nil)
+			if tc.expectSetTier {
+				blob.On("SetTier", azblobblob.AccessTierHot, &azblobblob.SetTierOptions{RehydratePriority: to.Ptr(azblobblob.RehydratePriorityHigh)}).Return(nil)
+			}
+
+			_, err := o.GetObject("b", "k")
+			var archivedErr *ArchivedObjectError
+			require.ErrorAs(t, err, &archivedErr)
+			assert.Equal(t, tc.expectedStatus, archivedErr.RehydrationStatus)
+
+			// the object exists even though it can't be read
+			exists, err := o.ObjectExists("b", "k")
+			require.NoError(t, err)
+			assert.True(t, exists)
+		})
+	}
+}
+
+func TestRehydratePrefix(t *testing.T) {
+	containerGetter := new(mockContainerGetter)
+	blobGetter := new(mockBlobGetter)
+	blob := new(mockBlob)
+	defer blob.AssertExpectations(t)
+
+	containerGetter.On("getContainer", "b").Return(&fakeContainer{
+		items: []*azcontainer.BlobItem{
+			{Name: to.Ptr("backups/b1/hot"), Properties: &azcontainer.BlobProperties{AccessTier: to.Ptr(azblobblob.AccessTierHot)}},
+			{Name: to.Ptr("backups/b1/archived"), Properties: &azcontainer.BlobProperties{AccessTier: to.Ptr(azblobblob.AccessTierArchive)}},
+			{Name: to.Ptr("backups/b1/rehydrating"), Properties: &azcontainer.BlobProperties{
+				AccessTier:    to.Ptr(azblobblob.AccessTierArchive),
+				ArchiveStatus: to.Ptr(azblobblob.ArchiveStatusRehydratePendingToHot),
+			}},
+		},
+	})
+	blobGetter.On("getBlob", "b", "backups/b1/archived").Return(blob)
+	blob.On("SetTier", azblobblob.AccessTierHot, mock.Anything).Return(nil).Once()
+
+	o := &ObjectStore{
+		log:             logrus.New(),
+		containerGetter: containerGetter,
+		blobGetter:      blobGetter,
+		rehydration:     rehydration{tier: azblobblob.AccessTierHot, priority: azblobblob.RehydratePriorityStandard},
+	}
+	require.NoError(t, o.RehydratePrefix("b", "backups/b1/"))
+}
@@ -0,0 +1,228 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxRetriesConfigKey       = "maxRetries"
+	retryDelayConfigKey       = "retryDelay"
+	maxRetryDelayConfigKey    = "maxRetryDelay"
+	retryStatusCodesConfigKey = "retryStatusCodes"
+	tryTimeoutConfigKey       = "tryTimeout"
+
+	// the defaults of the SDK
+	// ref. https://pkg.go.dev/github.com/Azure/azure-sdk-for-go/sdk/azcore/policy#RetryOptions
+	defaultMaxRetries    = 3
+	defaultRetryDelay    = 800 * time.Millisecond
+	defaultMaxRetryDelay = time.Minute
+)
+
+var defaultRetryStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// retryPolicy is the retry policy of the requests to Azure. The delay before a retry is the
+// one of the Retry-After header of the response when there is one, e.g. when throttled, and
+// grows exponentially from the base delay otherwise.
+type retryPolicy struct {
+	log        logrus.FieldLogger
+	maxRetries int32
+	delay      time.Duration
+	maxDelay   time.Duration
+	tryTimeout time.Duration
+	// the status codes of the responses that are retried
+	statusCodes []int
+}
+
+func getRetryPolicy(log logrus.FieldLogger, config map[string]string) (*retryPolicy, error) {
+	r := &retryPolicy{log: log, maxRetries: defaultMaxRetries, statusCodes: defaultRetryStatusCodes}
+
+	maxRetries, err := getIntConfig(config, maxRetriesConfigKey, defaultMaxRetries, 0, 100)
+	if err != nil {
+		return nil, err
+	}
+	r.maxRetries = int32(maxRetries)
+
+	for key, val := range map[string]*time.Duration{
+		retryDelayConfigKey:    &r.delay,
+		maxRetryDelayConfigKey: &r.maxDelay,
+		tryTimeoutConfigKey:    &r.tryTimeout,
+	} {
+		if config[key] == "" {
+			continue
+		}
+		if *val, err = time.ParseDuration(config[key]); err != nil {
+			return nil, errors.Wrapf(err, "unable to parse value %q for config key %q (expected a duration, e.g. 800ms or 1m)", config[key], key)
+		}
+		if *val <= 0 {
+			return nil, errors.Errorf("value %q for config key %q must be positive", config[key], key)
+		}
+	}
+	if r.delay > 0 && r.maxDelay > 0 && r.delay > r.maxDelay {
+		return nil, errors.Errorf("value of config key %q can't be greater than the one of config key %q", retryDelayConfigKey, maxRetryDelayConfigKey)
+	}
+
+	if val := config[retryStatusCodesConfigKey]; val != "" {
+		r.statusCodes = nil
+		for _, code := range strings.Split(val, ",") {
+			statusCode, err := strconv.Atoi(strings.TrimSpace(code))
+			if err != nil || statusCode < 100 || statusCode > 599 {
+				return nil, errors.Errorf("unable to parse value %q for config key %q (expected a comma-separated list of HTTP status codes)", val, retryStatusCodesConfigKey)
+			}
+			r.statusCodes = append(r.statusCodes, statusCode)
+		}
+	}
+
+	return r, nil
+}
+
+// retryStatusCode makes sure the responses with the status code are retried
+func (r *retryPolicy) retryStatusCode(statusCode int) {
+	if !slices.Contains(r.statusCodes, statusCode) {
+		r.statusCodes = append(slices.Clone(r.statusCodes), statusCode)
+	}
+}
+
+// retryDelay returns the delay before retrying after the attempt, for the retries the plugin
+// makes itself. It grows exponentially from the base delay like the one of the SDK.
+func (r *retryPolicy) retryDelay(attempt int) time.Duration {
+	delay, maxDelay := defaultRetryDelay, defaultMaxRetryDelay
+	if r != nil {
+		if r.delay > 0 {
+			delay = r.delay
+		}
+		if r.maxDelay > 0 {
+			maxDelay = r.maxDelay
+		}
+	}
+	// the delay would only overflow past the max delay anyway
+	if attempt >= 16 {
+		return maxDelay
+	}
+	return min(time.Duration(1<<attempt-1)*delay, maxDelay)
+}
+
+// options returns the retry options of a request of the operation, which count the attempts of
+// the request in attempts. They must not be shared between requests.
+func (r *retryPolicy) options(operation string, attempts *retryAttempts) policy.RetryOptions {
+	options := policy.RetryOptions{
+		// a negative value disables the retries in the SDK
+		MaxRetries:    -1,
+		RetryDelay:    r.delay,
+		MaxRetryDelay: r.maxDelay,
+		TryTimeout:    r.tryTimeout,
+	}
+	if r.maxRetries > 0 {
+		options.MaxRetries = r.maxRetries
+	}
+
+	options.ShouldRetry = func(resp *http.Response, err error) bool {
+		attempts.attempt++
+		attempts.failure = nil
+		if isThrottled(resp) {
+			throttledRequests.WithLabelValues(operation).Inc()
+		}
+		// like the SDK, errors of the connection are always retried
+		if err == nil && !slices.Contains(r.statusCodes, resp.StatusCode) {
+			return false
+		}
+		// the SDK may still give up, e.g. when the delay of the Retry-After header exceeds the
+		// max delay, the failure is only logged once the request is actually retried
+		log := r.log.WithFields(logrus.Fields{"operation": operation, "attempt": attempts.attempt})
+		if err != nil {
+			log = log.WithError(err)
+		} else {
+			log = log.WithField("statusCode", resp.StatusCode)
+			if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+				log = log.WithField("retryAfter", retryAfter)
+			}
+		}
+		attempts.failure = log
+		return true
+	}
+	return options
+}
+
+// context returns a context whose requests follow the retry policy, it must only be used for a
+// single request
+func (r *retryPolicy) context(ctx context.Context, operation string) context.Context {
+	if r == nil {
+		return ctx
+	}
+	attempts := &retryAttempts{operation: operation, maxRetries: r.maxRetries}
+	ctx = context.WithValue(ctx, retryAttemptsContextKey{}, attempts)
+	return policy.WithRetryOptions(ctx, r.options(operation, attempts))
+}
+
+type retryAttemptsContextKey struct{}
+
+// retryAttempts counts the attempts of a request, and holds the failure of the last one until
+// the request is retried
+type retryAttempts struct {
+	operation  string
+	maxRetries int32
+	attempt    int32
+	// the log of the failure of the last attempt, nil when it isn't retried
+	failure *logrus.Entry
+}
+
+// retryLogPolicy logs the failure of the previous attempt of a request when it's retried. It
+// must be one of the per-retry policies of the clients whose requests follow a retry policy.
+type retryLogPolicy struct{}
+
+func (retryLogPolicy) Do(req *policy.Request) (*http.Response, error) {
+	if attempts, ok := req.Raw().Context().Value(retryAttemptsContextKey{}).(*retryAttempts); ok && attempts.failure != nil {
+		attempts.failure.Infof("Request of operation %s failed, retrying (attempt %d of %d)", attempts.operation, attempts.attempt+1, attempts.maxRetries+1)
+		attempts.failure = nil
+	}
+	return req.Next()
+}
+
+type operationContextKey struct{}
+
+// withOperation names the operation of the requests of the context in the logs of their retries
+func withOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationContextKey{}, operation)
+}
+
+// Do applies the retry policy to every request of the clients the policy is added to, which
+// is needed for requests sharing a context, such as the polls of a long-running operation.
+func (r *retryPolicy) Do(req *policy.Request) (*http.Response, error) {
+	ctx := req.Raw().Context()
+	operation, ok := ctx.Value(operationContextKey{}).(string)
+	if !ok {
+		operation = req.Raw().Method
+	}
+	return req.WithContext(r.context(ctx, operation)).Next()
+}
@@ -0,0 +1,167 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRetryPolicy(t *testing.T) {
+	r, err := getRetryPolicy(logrus.New(), map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(defaultMaxRetries), r.maxRetries)
+	assert.Equal(t, defaultRetryStatusCodes, r.statusCodes)
+
+	r, err = getRetryPolicy(logrus.New(), map[string]string{
+		maxRetriesConfigKey:       "5",
+		retryDelayConfigKey:       "1s",
+		maxRetryDelayConfigKey:    "2m",
+		retryStatusCodesConfigKey: "500, 503",
+		tryTimeoutConfigKey:       "30s",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), r.maxRetries)
+	assert.Equal(t, time.Second, r.delay)
+	assert.Equal(t, 2*time.Minute, r.maxDelay)
+	assert.Equal(t, 30*time.Second, r.tryTimeout)
+	assert.Equal(t, []int{500, 503}, r.statusCodes)
+
+	r.retryStatusCode(http.StatusTooManyRequests)
+	assert.Equal(t, []int{500, 503, 429}, r.statusCodes)
+
+	for _, config := range []map[string]string{
+		{maxRetriesConfigKey: "-1"},
+		{retryDelayConfigKey: "1"},
+		{tryTimeoutConfigKey: "0s"},
+		{retryDelayConfigKey: "2m", maxRetryDelayConfigKey: "1m"},
+		{retryStatusCodesConfigKey: "500,abc"},
+		{retryStatusCodesConfigKey: "42"},
+	} {
+		_, err := getRetryPolicy(logrus.New(), config)
+		assert.Error(t, err, config)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	// the defaults of the SDK
+	var r *retryPolicy
+	assert.Equal(t, defaultRetryDelay, r.retryDelay(1))
+	assert.Equal(t, 3*defaultRetryDelay, r.retryDelay(2))
+
+	r, err := getRetryPolicy(logrus.New(), map[string]string{retryDelayConfigKey: "1s", maxRetryDelayConfigKey: "5s"})
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, r.retryDelay(1))
+	assert.Equal(t, 3*time.Second, r.retryDelay(2))
+	assert.Equal(t, 5*time.Second, r.retryDelay(3))
+	assert.Equal(t, 5*time.Second, r.retryDelay(100))
+}
+
+// newRetryServer returns a server answering the requests with the status codes, one per request
+func newRetryServer(t *testing.T, statusCodes ...int) (*httptest.Server, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After-Ms", "1")
+		w.WriteHeader(statusCodes[min(requests, len(statusCodes)-1)])
+		requests++
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestRetryPolicyLogsRetries(t *testing.T) {
+	log, hook := test.NewNullLogger()
+	r, err := getRetryPolicy(log, map[string]string{retryDelayConfigKey: "1ms"})
+	require.NoError(t, err)
+	r.retryStatusCode(http.StatusTooManyRequests)
+
+	// the polls of a long-running operation share a context, so the policy of the clients
+	// counts the attempts of every request
+	server, requests := newRetryServer(t, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK)
+	pipeline := azruntime.NewPipeline("test", "v1", azruntime.PipelineOptions{}, &policy.ClientOptions{
+		PerCallPolicies:  []policy.Policy{r},
+		PerRetryPolicies: []policy.Policy{retryLogPolicy{}},
+	})
+	for i := 0; i < 2; i++ {
+		req, err := azruntime.NewRequest(withOperation(context.Background(), "CreateSnapshot"), http.MethodGet, server.URL)
+		require.NoError(t, err)
+		resp, err := pipeline.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.Equal(t, 4, *requests)
+
+	require.Len(t, hook.Entries, 2)
+	assert.Equal(t, "Request of operation CreateSnapshot failed, retrying (attempt 2 of 4)", hook.Entries[0].Message)
+	assert.Equal(t, "CreateSnapshot", hook.Entries[0].Data["operation"])
+	assert.Equal(t, int32(1), hook.Entries[0].Data["attempt"])
+	assert.Equal(t, http.StatusTooManyRequests, hook.Entries[0].Data["statusCode"])
+	assert.Equal(t, "Request of operation CreateSnapshot failed, retrying (attempt 3 of 4)", hook.Entries[1].Message)
+}
+
+func TestRetryPolicyGivesUp(t *testing.T) {
+	log, hook := test.NewNullLogger()
+	r, err := getRetryPolicy(log, map[string]string{maxRetriesConfigKey: "1", retryStatusCodesConfigKey: "500"})
+	require.NoError(t, err)
+
+	server, requests := newRetryServer(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+	pipeline := azruntime.NewPipeline("test", "v1", azruntime.PipelineOptions{}, &policy.ClientOptions{PerRetryPolicies: []policy.Policy{retryLogPolicy{}}})
+	req, err := azruntime.NewRequest(r.context(context.Background(), operationList), http.MethodGet, server.URL)
+	require.NoError(t, err)
+	resp, err := pipeline.Do(req)
+	require.NoError(t, err)
+
+	// 503 isn't retried with the status codes of the config
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, 2, *requests)
+	assert.Len(t, hook.Entries, 1)
+}
+
+func TestRetryPolicyRetryAfterExceedsMaxDelay(t *testing.T) {
+	log, hook := test.NewNullLogger()
+	r, err := getRetryPolicy(log, map[string]string{maxRetryDelayConfigKey: "1m"})
+	require.NoError(t, err)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		requests++
+	}))
+	t.Cleanup(server.Close)
+
+	pipeline := azruntime.NewPipeline("test", "v1", azruntime.PipelineOptions{}, &policy.ClientOptions{PerRetryPolicies: []policy.Policy{retryLogPolicy{}}})
+	req, err := azruntime.NewRequest(r.context(context.Background(), operationList), http.MethodGet, server.URL)
+	require.NoError(t, err)
+	resp, err := pipeline.Do(req)
+	require.NoError(t, err)
+
+	// the SDK doesn't wait longer than the max delay, the request isn't retried nor logged as such
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, 1, requests)
+	assert.Empty(t, hook.Entries)
+}
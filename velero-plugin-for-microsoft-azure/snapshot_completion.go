@@ -0,0 +1,83 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const vslConfigKeyWaitForSnapshotCompletion = "waitForSnapshotCompletion"
+
+// waitForSnapshotCompletion waits, up to apiTimeout, for the data of a snapshot to be copied in
+// the background. Incremental snapshots are created before their data is copied, they can't be
+// restored or copied to another region until it is.
+// ref. https://learn.microsoft.com/en-us/azure/virtual-machines/disks-incremental-snapshots#check-snapshot-status
+func (b *VolumeSnapshotter) waitForSnapshotCompletion(ctx context.Context, resourceGroup, name string) (err error) {
+	ctx, span := startSpan(ctx, "WaitForSnapshotCompletion", trace.WithAttributes(attribute.String("snapshot", name)))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := context.WithTimeout(ctx, b.apiTimeout)
+	defer cancel()
+
+	log := b.log.WithField("snapshot", name)
+	start := time.Now()
+	var progress float32
+	timeoutErr := func() error {
+		return errors.Errorf("snapshot %s is only %.1f%% complete after %s (the timeout set by config key %q)", name, progress, b.apiTimeout, vslConfigKeyAPITimeout)
+	}
+	for polls := 0; ; polls++ {
+		res, err := b.snaps.Get(ctx, resourceGroup, name, nil)
+		if err != nil && ctx.Err() != nil {
+			return timeoutErr()
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error getting the completion of snapshot %s", name)
+		}
+		if res.Properties != nil && res.Properties.CopyCompletionError != nil {
+			copyErr := res.Properties.CopyCompletionError
+			return errors.Errorf("the data of snapshot %s couldn't be copied: %s: %s", name, deref(copyErr.ErrorCode), deref(copyErr.ErrorMessage))
+		}
+		// the completion is only reported while the data is being copied
+		if res.Properties == nil || res.Properties.CompletionPercent == nil || *res.Properties.CompletionPercent >= 100 {
+			log.Infof("Snapshot %s completed after %s", name, time.Since(start).Round(time.Second))
+			return nil
+		}
+
+		if percent := *res.Properties.CompletionPercent; polls == 0 || percent != progress {
+			log.Infof("Snapshot %s is %.1f%% complete", name, percent)
+			progress = percent
+		}
+		select {
+		case <-time.After(pollingDelay):
+		case <-ctx.Done():
+			return timeoutErr()
+		}
+	}
+}
+
+// deref returns the value of the pointer, or the zero value if it's nil
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
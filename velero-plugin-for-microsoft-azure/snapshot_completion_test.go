@@ -0,0 +1,69 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snapshotResponse returns the body of a snapshot with the properties
+func snapshotResponse(name, properties string) string {
+	return fmt.Sprintf(`{"id": "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/snapshots/%s", "name": %q, "location": "westeurope", "properties": {%s}}`, name, name, properties)
+}
+
+func TestWaitForSnapshotCompletion(t *testing.T) {
+	progress := []string{`"completionPercent": 40`, `"completionPercent": 40`, `"completionPercent": 85.5`, `"completionPercent": 100`}
+	requests := 0
+	b := newFakeARMSnapshotter(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/snapshots/snap", r.URL.Path)
+		fmt.Fprint(w, snapshotResponse("snap", progress[requests]))
+		requests++
+	})
+	logger, hook := test.NewNullLogger()
+	b.log = logger
+
+	require.NoError(t, b.waitForSnapshotCompletion(context.Background(), "rg", "snap"))
+	assert.Equal(t, 4, requests)
+	var messages []string
+	for _, entry := range hook.AllEntries() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Equal(t, []string{"Snapshot snap is 40.0% complete", "Snapshot snap is 85.5% complete", "Snapshot snap completed after 0s"}, messages)
+}
+
+func TestWaitForSnapshotCompletionFails(t *testing.T) {
+	b := newFakeARMSnapshotter(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, snapshotResponse("snap", `"completionPercent": 10, "copyCompletionError": {"errorCode": "CopySourceNotFound", "errorMessage": "the source was deleted"}`))
+	})
+	assert.EqualError(t, b.waitForSnapshotCompletion(context.Background(), "rg", "snap"), "the data of snapshot snap couldn't be copied: CopySourceNotFound: the source was deleted")
+
+	b = newFakeARMSnapshotter(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, snapshotResponse("snap", `"completionPercent": 10`))
+	})
+	// the timeout expires while waiting for the next poll, not during the first one
+	pollingDelay = time.Minute
+	b.apiTimeout = 500 * time.Millisecond
+	assert.EqualError(t, b.waitForSnapshotCompletion(context.Background(), "rg", "snap"), `snapshot snap is only 10.0% complete after 500ms (the timeout set by config key "apiTimeout")`)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	vslConfigKeyCopyRegions   = "copyRegions"
+	vslConfigKeyRestoreRegion = "restoreRegion"
+	// whether to copy the snapshots in other regions to the restore region before restoring them
+	vslConfigKeyCopyToRestoreRegion = "copyToRestoreRegion"
+
+	// the separator of the copies of a snapshot in its snapshot ID
+	snapshotCopySeparator = ";"
+)
+
+// snapshotCopy is a copy of a snapshot in another region
+type snapshotCopy struct {
+	region string
+	id     *snapshotIdentifier
+}
+
+// snapshotCopyTarget is a region the snapshots are copied to, and the resource group of the copies
+type snapshotCopyTarget struct {
+	region        string
+	resourceGroup string
+}
+
+// parseSnapshotCopyTargets parses the regions the snapshots are copied to, formatted as
+// "region1:resourceGroup1,region2". The copies are in the resource group of the snapshots
+// when a region has none.
+func parseSnapshotCopyTargets(val, defaultResourceGroup string) ([]snapshotCopyTarget, error) {
+	var targets []snapshotCopyTarget
+	for _, target := range strings.Split(val, ",") {
+		region, resourceGroup, _ := strings.Cut(strings.TrimSpace(target), ":")
+		if region == "" || strings.ContainsAny(region+resourceGroup, snapshotCopySeparator+"=") {
+			return nil, errors.Errorf("unable to parse value %q for config key %q (the valid format is \"region1:resourceGroup1,region2\")", val, vslConfigKeyCopyRegions)
+		}
+		if resourceGroup == "" {
+			resourceGroup = defaultResourceGroup
+		}
+		targets = append(targets, snapshotCopyTarget{region: normalizeRegion(region), resourceGroup: resourceGroup})
+	}
+	return targets, nil
+}
+
+// normalizeRegion returns the name of a region as ARM returns it, e.g. "westeurope" for "West Europe"
+func normalizeRegion(region string) string {
+	return strings.ToLower(strings.ReplaceAll(region, " ", ""))
+}
+
+// copyIn returns the copy of the snapshot in the region, or nil if there is none
+func (si *snapshotIdentifier) copyIn(region string) *snapshotIdentifier {
+	for _, c := range si.copies {
+		if region != "" && c.region == region {
+			return c.id
+		}
+	}
+	return nil
+}
+
+// copySnapshot copies the snapshot to the configured regions, recording the copies in the
+// snapshot identifier. The data of the copies is copied in the background, the copies are
+// waited for when waitForSnapshotCompletion is set, and before restoring from them otherwise.
+// ref. https://learn.microsoft.com/en-us/azure/virtual-machines/disks-copy-incremental-snapshot-across-regions
+func (b *VolumeSnapshotter) copySnapshot(ctx context.Context, snapshotID *snapshotIdentifier, diskName, location string, tags map[string]*string) error {
+	for _, target := range b.snapsCopyTargets {
+		if target.region == normalizeRegion(location) {
+			b.log.Debugf("Snapshot %s is already in region %s, not copying it", snapshotID.name, target.region)
+			continue
+		}
+
+		name, err := newSnapshotName(diskName)
+		if err != nil {
+			return err
+		}
+		copyID := &snapshotIdentifier{subscription: snapshotID.subscription, resourceGroup: target.resourceGroup, name: name}
+
+		b.log.Infof("Copying snapshot %s to region %s", snapshotID.name, target.region)
+		// the copy is recorded even if its creation fails, so that it's deleted with the snapshot
+		snapshotID.copies = append(snapshotID.copies, snapshotCopy{region: target.region, id: copyID})
+		if err := b.createSnapshot(ctx, copyID, newSnapshotCopy(snapshotID, target.region, tags), "CreateSnapshot"); err != nil {
+			return errors.Wrapf(err, "error copying snapshot %s to region %s", snapshotID.name, target.region)
+		}
+
+		if b.snapsWaitForCompletion {
+			if err := b.waitForSnapshotCompletion(ctx, copyID.resourceGroup, copyID.name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copySnapshotToRegion copies the snapshot to the region, for a disk to be restored from the
+// copy there, and waits for the data of the copy. The copy is returned as soon as its creation
+// starts, to be deleted whether or not it succeeds.
+func (b *VolumeSnapshotter) copySnapshotToRegion(ctx context.Context, snapshotID *snapshotIdentifier, snapshotInfo armcompute.Snapshot, region string) (*snapshotIdentifier, error) {
+	if snapshotInfo.Properties == nil || !deref(snapshotInfo.Properties.Incremental) {
+		return nil, errors.Errorf("snapshot %s is in region %s and can't be copied to region %s, only incremental snapshots can", snapshotID.name, deref(snapshotInfo.Location), region)
+	}
+
+	name, err := newSnapshotName("restore")
+	if err != nil {
+		return nil, err
+	}
+	copyID := &snapshotIdentifier{subscription: b.snapsSubscription, resourceGroup: b.snapsResourceGroup, name: name}
+
+	b.log.Infof("Copying snapshot %s from region %s to region %s to restore it", snapshotID.name, deref(snapshotInfo.Location), region)
+	if err := b.createSnapshot(ctx, copyID, newSnapshotCopy(snapshotID, region, snapshotInfo.Tags), "CreateVolumeFromSnapshot"); err != nil {
+		return copyID, errors.Wrapf(err, "error copying snapshot %s to region %s", snapshotID.name, region)
+	}
+	return copyID, b.waitForSnapshotCompletion(ctx, copyID.resourceGroup, copyID.name)
+}
+
+// deleteIntermediateSnapshot deletes a copy of a snapshot made for a restore, logging the errors
+// since the restore doesn't depend on it
+func (b *VolumeSnapshotter) deleteIntermediateSnapshot(ctx context.Context, snapshotID *snapshotIdentifier) {
+	if err := b.deleteSnapshot(ctx, snapshotID); err != nil {
+		b.log.WithError(err).Warnf("Error deleting snapshot %s copied for the restore, it must be deleted manually", snapshotID.String())
+	}
+}
+
+// newSnapshotCopy returns an incremental copy of the snapshot in the region
+func newSnapshotCopy(snapshotID *snapshotIdentifier, region string, tags map[string]*string) armcompute.Snapshot {
+	return armcompute.Snapshot{
+		Properties: &armcompute.SnapshotProperties{
+			CreationData: &armcompute.CreationData{
+				CreateOption:     to.Ptr(armcompute.DiskCreateOptionCopyStart),
+				SourceResourceID: to.Ptr(snapshotID.String()),
+			},
+			Incremental: to.Ptr(true),
+		},
+		Tags:     tags,
+		Location: to.Ptr(region),
+	}
+}
+
+// createSnapshot creates the snapshot and waits, up to apiTimeout, for its creation to complete
+func (b *VolumeSnapshotter) createSnapshot(ctx context.Context, snapshotID *snapshotIdentifier, snap armcompute.Snapshot, operation string) error {
+	ctx, cancel := context.WithTimeout(ctx, b.apiTimeout)
+	defer cancel()
+
+	pollerResp, err := b.snaps.BeginCreateOrUpdate(ctx, snapshotID.resourceGroup, snapshotID.name, snap, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = pollUntilDone(ctx, pollerResp, operation)
+	return errors.WithStack(err)
+}
@@ -0,0 +1,234 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSnapshotCopyTargets(t *testing.T) {
+	targets, err := parseSnapshotCopyTargets("North Europe:dr-rg, westus2", "rg")
+	require.NoError(t, err)
+	assert.Equal(t, []snapshotCopyTarget{{region: "northeurope", resourceGroup: "dr-rg"}, {region: "westus2", resourceGroup: "rg"}}, targets)
+
+	for _, val := range []string{"westus2,", ":dr-rg", "westus2=dr-rg", "westus2:dr;rg"} {
+		_, err = parseSnapshotCopyTargets(val, "rg")
+		assert.Error(t, err, val)
+	}
+}
+
+func TestFullSnapshotNameWithCopies(t *testing.T) {
+	fullName := "/subscriptions/sub-1/resourceGroups/rg-1/providers/Microsoft.Compute/snapshots/snap-1" +
+		";northeurope=/subscriptions/sub-1/resourceGroups/dr-rg/providers/Microsoft.Compute/snapshots/snap-2"
+	snap, err := parseFullSnapshotName(fullName)
+	require.NoError(t, err)
+
+	assert.Equal(t, "snap-1", snap.name)
+	assert.Equal(t, "/subscriptions/sub-1/resourceGroups/rg-1/providers/Microsoft.Compute/snapshots/snap-1", snap.String())
+	assert.Equal(t, fullName, snap.fullName())
+	assert.Equal(t, &snapshotIdentifier{subscription: "sub-1", resourceGroup: "dr-rg", name: "snap-2"}, snap.copyIn("northeurope"))
+	assert.Nil(t, snap.copyIn("westeurope"))
+	assert.Nil(t, snap.copyIn(""))
+
+	_, err = parseFullSnapshotName(fullName + ";northeurope")
+	assert.Error(t, err)
+}
+
+// fakeCompute is the state of the disks and snapshots of a fake ARM server
+type fakeCompute struct {
+	t         *testing.T
+	disks     map[string]string
+	snapshots map[string]armcompute.Snapshot
+	// the regions where snapshots fail to be created or deleted
+	failures       map[string]bool
+	deleteFailures map[string]bool
+	deleted        []string
+}
+
+func newFakeCompute(t *testing.T) *fakeCompute {
+	return &fakeCompute{t: t, disks: map[string]string{}, snapshots: map[string]armcompute.Snapshot{}, failures: map[string]bool{}, deleteFailures: map[string]bool{}}
+}
+
+func (c *fakeCompute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resourceType, name := path.Base(path.Dir(r.URL.Path)), path.Base(r.URL.Path)
+	switch {
+	case resourceType == disksResource && r.Method == http.MethodGet && c.disks[name] != "":
+		fmt.Fprintf(w, `{"name": %q, "location": %q, "sku": {"name": "Premium_LRS"}, "properties": {}}`, name, c.disks[name])
+	case resourceType == snapshotsResource && r.Method == http.MethodGet && c.snapshots[name].Name != nil:
+		assert.NoError(c.t, json.NewEncoder(w).Encode(c.snapshots[name]))
+	case resourceType == snapshotsResource && r.Method == http.MethodPut:
+		var snap armcompute.Snapshot
+		assert.NoError(c.t, json.NewDecoder(r.Body).Decode(&snap))
+		if c.failures[deref(snap.Location)] {
+			http.Error(w, `{"error": {"code": "OperationNotAllowed"}}`, http.StatusBadRequest)
+			return
+		}
+		snap.Name = &name
+		snap.ID = to.Ptr(r.URL.Path)
+		snap.Properties.ProvisioningState = to.Ptr("Succeeded")
+		c.snapshots[name] = snap
+		assert.NoError(c.t, json.NewEncoder(w).Encode(snap))
+	case resourceType == snapshotsResource && r.Method == http.MethodDelete:
+		if c.deleteFailures[deref(c.snapshots[name].Location)] {
+			http.Error(w, `{"error": {"code": "OperationNotAllowed"}}`, http.StatusConflict)
+			return
+		}
+		c.deleted = append(c.deleted, strings.Split(r.URL.Path, "/")[4]+"/"+name)
+		delete(c.snapshots, name)
+	default:
+		http.Error(w, `{"error": {"code": "NotFound"}}`, http.StatusNotFound)
+	}
+}
+
+func TestCreateSnapshotCopies(t *testing.T) {
+	compute := newFakeCompute(t)
+	compute.disks["disk-1"] = "westeurope"
+	b := newFakeARMSnapshotter(t, compute.ServeHTTP)
+	b.snapsIncremental = to.Ptr(true)
+	b.snapsCopyTargets = []snapshotCopyTarget{{region: "westeurope", resourceGroup: "rg"}, {region: "northeurope", resourceGroup: "dr-rg"}}
+
+	snapshotID, err := b.CreateSnapshot("disk-1", "westeurope-1", nil)
+	require.NoError(t, err)
+
+	snap, err := parseFullSnapshotName(snapshotID)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(snap.name, "disk-1-"))
+	require.Len(t, snap.copies, 1)
+	assert.Equal(t, "northeurope", snap.copies[0].region)
+	assert.Equal(t, "dr-rg", snap.copies[0].id.resourceGroup)
+
+	copied := compute.snapshots[snap.copies[0].id.name]
+	assert.Equal(t, "northeurope", *copied.Location)
+	assert.Equal(t, armcompute.DiskCreateOptionCopyStart, *copied.Properties.CreationData.CreateOption)
+	assert.Equal(t, snap.String(), *copied.Properties.CreationData.SourceResourceID)
+	assert.True(t, *copied.Properties.Incremental)
+
+	// the copies are deleted with the snapshot
+	require.NoError(t, b.DeleteSnapshot(snapshotID))
+	assert.Equal(t, []string{"dr-rg/" + snap.copies[0].id.name, "rg/" + snap.name}, compute.deleted)
+}
+
+func TestDeleteSnapshotCopyFails(t *testing.T) {
+	compute := newFakeCompute(t)
+	compute.disks["disk-1"] = "westeurope"
+	b := newFakeARMSnapshotter(t, compute.ServeHTTP)
+	b.snapsIncremental = to.Ptr(true)
+	b.snapsCopyTargets = []snapshotCopyTarget{{region: "northeurope", resourceGroup: "dr-rg"}, {region: "westus2", resourceGroup: "dr-rg"}}
+
+	snapshotID, err := b.CreateSnapshot("disk-1", "westeurope-1", nil)
+	require.NoError(t, err)
+	snap, err := parseFullSnapshotName(snapshotID)
+	require.NoError(t, err)
+	require.Len(t, snap.copies, 2)
+
+	// the other copy and the snapshot are deleted even though the deletion of a copy fails
+	compute.deleteFailures["northeurope"] = true
+	err = b.DeleteSnapshot(snapshotID)
+	assert.ErrorContains(t, err, "error deleting the copy of the snapshot in region northeurope")
+	assert.Equal(t, []string{"dr-rg/" + snap.copies[1].id.name, "rg/" + snap.name}, compute.deleted)
+	assert.Len(t, compute.snapshots, 1)
+}
+
+func TestCreateSnapshotCopyFails(t *testing.T) {
+	compute := newFakeCompute(t)
+	compute.disks["disk-1"] = "westeurope"
+	compute.failures["northeurope"] = true
+	b := newFakeARMSnapshotter(t, compute.ServeHTTP)
+	b.snapsIncremental = to.Ptr(true)
+	b.snapsCopyTargets = []snapshotCopyTarget{{region: "northeurope", resourceGroup: "dr-rg"}}
+
+	_, err := b.CreateSnapshot("disk-1", "westeurope-1", nil)
+	assert.ErrorContains(t, err, "error copying snapshot disk-1-")
+	// the snapshot is deleted, its copy was never created
+	assert.Empty(t, compute.snapshots)
+	require.Len(t, compute.deleted, 1)
+	assert.True(t, strings.HasPrefix(compute.deleted[0], "rg/disk-1-"))
+}
+
+func TestCreateVolumeFromSnapshotCopy(t *testing.T) {
+	compute := newFakeCompute(t)
+	compute.snapshots["snap-2"] = armcompute.Snapshot{Name: to.Ptr("snap-2"), Location: to.Ptr("northeurope"), Properties: &armcompute.SnapshotProperties{CompletionPercent: to.Ptr[float32](100)}}
+	var disk armcompute.Disk
+	b := newFakeARMSnapshotter(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/disks/") {
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&disk))
+			fmt.Fprint(w, `{"location": "northeurope", "properties": {"provisioningState": "Succeeded"}}`)
+			return
+		}
+		compute.ServeHTTP(w, r)
+	})
+	b.restoreRegion = "northeurope"
+
+	volumeID, err := b.CreateVolumeFromSnapshot("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/snapshots/snap-1"+
+		";northeurope=/subscriptions/sub/resourceGroups/dr-rg/providers/Microsoft.Compute/snapshots/snap-2", "Premium_LRS", "", nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(volumeID, "restore-"))
+	assert.Equal(t, "northeurope", *disk.Location)
+	assert.Equal(t, "/subscriptions/sub/resourceGroups/dr-rg/providers/Microsoft.Compute/snapshots/snap-2", *disk.Properties.CreationData.SourceResourceID)
+}
+
+func TestCreateVolumeFromSnapshotInOtherRegion(t *testing.T) {
+	compute := newFakeCompute(t)
+	compute.snapshots["snap-1"] = armcompute.Snapshot{Name: to.Ptr("snap-1"), Location: to.Ptr("westeurope"), Properties: &armcompute.SnapshotProperties{Incremental: to.Ptr(true)}}
+	var disk armcompute.Disk
+	b := newFakeARMSnapshotter(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/disks/") {
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&disk))
+			fmt.Fprint(w, `{"location": "northeurope", "properties": {"provisioningState": "Succeeded"}}`)
+			return
+		}
+		compute.ServeHTTP(w, r)
+	})
+	b.restoreRegion = "northeurope"
+	b.copyToRestoreRegion = true
+
+	_, err := b.CreateVolumeFromSnapshot("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/snapshots/snap-1", "Premium_LRS", "westeurope-1", nil)
+	require.NoError(t, err)
+
+	// the disk is restored from a copy of the snapshot in the restore region, without the zone of the other region
+	assert.Equal(t, "northeurope", *disk.Location)
+	assert.Empty(t, disk.Zones)
+	source := *disk.Properties.CreationData.SourceResourceID
+	assert.True(t, strings.HasPrefix(source, "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/snapshots/restore-"), source)
+
+	// the copy is deleted once the disk is created
+	require.Len(t, compute.deleted, 1)
+	assert.Equal(t, "rg/"+path.Base(source), compute.deleted[0])
+	assert.Len(t, compute.snapshots, 1)
+}
+
+func TestCreateVolumeFromFullSnapshotInOtherRegion(t *testing.T) {
+	compute := newFakeCompute(t)
+	compute.snapshots["snap-1"] = armcompute.Snapshot{Name: to.Ptr("snap-1"), Location: to.Ptr("westeurope"), Properties: &armcompute.SnapshotProperties{}}
+	b := newFakeARMSnapshotter(t, compute.ServeHTTP)
+	b.restoreRegion = "northeurope"
+	b.copyToRestoreRegion = true
+
+	_, err := b.CreateVolumeFromSnapshot("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/snapshots/snap-1", "Premium_LRS", "", nil)
+	assert.ErrorContains(t, err, "only incremental snapshots can")
+	assert.Empty(t, compute.deleted)
+}
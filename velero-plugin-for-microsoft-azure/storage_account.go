@@ -0,0 +1,148 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/vmware-tanzu/velero/pkg/util/azure"
+)
+
+const (
+	encryptionScopeConfigKey = "encryptionScope"
+	// the management plane may not be reachable with the credential of the object store, don't
+	// hold up the initialization or the commands for long
+	managementPlaneTimeout = 10 * time.Second
+)
+
+// storageAccount gives access to the management plane of the storage account, to check
+// settings that can't be read through the blob service
+type storageAccount interface {
+	// ID identifies the storage account
+	ID() string
+	GetEncryptionScope(name string) (*armstorage.EncryptionScope, error)
+	GetBlobServiceProperties() (*armstorage.BlobServicePropertiesProperties, error)
+}
+
+type azureStorageAccount struct {
+	subscriptionID   string
+	name             string
+	resourceGroup    string
+	encryptionScopes *armstorage.EncryptionScopesClient
+	blobServices     *armstorage.BlobServicesClient
+}
+
+// newStorageAccount returns nil when the resource group or the subscription of the storage
+// account isn't known, e.g. when only the URI of the storage account is configured
+func newStorageAccount(config map[string]string) (storageAccount, error) {
+	creds, err := azure.LoadCredentials(config)
+	if err != nil {
+		return nil, err
+	}
+
+	resourceGroup := config[azure.BSLConfigResourceGroup]
+	subscriptionID := azure.GetFromLocationConfigOrCredential(config, creds, azure.BSLConfigSubscriptionID, azure.CredentialKeySubscriptionID)
+	if resourceGroup == "" || subscriptionID == "" {
+		return nil, nil
+	}
+
+	clientOptions, err := azure.GetClientOptions(config, creds)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := azure.NewCredential(creds, clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	factory, err := armstorage.NewClientFactory(subscriptionID, credential, &arm.ClientOptions{ClientOptions: clientOptions})
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating storage account client")
+	}
+
+	return &azureStorageAccount{
+		subscriptionID:   subscriptionID,
+		name:             config[azure.BSLConfigStorageAccount],
+		resourceGroup:    resourceGroup,
+		encryptionScopes: factory.NewEncryptionScopesClient(),
+		blobServices:     factory.NewBlobServicesClient(),
+	}, nil
+}
+
+func (a *azureStorageAccount) ID() string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s", a.subscriptionID, a.resourceGroup, a.name)
+}
+
+func (a *azureStorageAccount) GetEncryptionScope(name string) (*armstorage.EncryptionScope, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), managementPlaneTimeout)
+	defer cancel()
+
+	res, err := a.encryptionScopes.Get(ctx, a.resourceGroup, a.name, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &res.EncryptionScope, nil
+}
+
+func (a *azureStorageAccount) GetBlobServiceProperties() (*armstorage.BlobServicePropertiesProperties, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), managementPlaneTimeout)
+	defer cancel()
+
+	res, err := a.blobServices.GetServiceProperties(ctx, a.resourceGroup, a.name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.BlobServiceProperties.BlobServiceProperties == nil {
+		return &armstorage.BlobServicePropertiesProperties{}, nil
+	}
+	return res.BlobServiceProperties.BlobServiceProperties, nil
+}
+
+// getEncryptionScope checks that the encryption scope exists and is enabled, so that a
+// misconfigured scope fails the initialization rather than the first backup
+func getEncryptionScope(log logrus.FieldLogger, account storageAccount, name string) (*azblobblob.CPKScopeInfo, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	if account == nil {
+		log.Warnf("The resource group or subscription of the storage account isn't configured, unable to check encryption scope %s", name)
+		return &azblobblob.CPKScopeInfo{EncryptionScope: &name}, nil
+	}
+
+	scope, err := account.GetEncryptionScope(name)
+	if err != nil {
+		var responseErr *azcore.ResponseError
+		if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotFound {
+			return nil, errors.Errorf("encryption scope %s not found in the storage account", name)
+		}
+		return nil, errors.Wrapf(err, "error getting encryption scope %s", name)
+	}
+	if scope.EncryptionScopeProperties == nil || scope.EncryptionScopeProperties.State == nil || *scope.EncryptionScopeProperties.State != armstorage.EncryptionScopeStateEnabled {
+		return nil, errors.Errorf("encryption scope %s is not enabled", name)
+	}
+
+	return &azblobblob.CPKScopeInfo{EncryptionScope: &name}, nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type mockStorageAccount struct {
+	mock.Mock
+	id string
+}
+
+func (m *mockStorageAccount) ID() string {
+	return m.id
+}
+
+func (m *mockStorageAccount) GetBlobServiceProperties() (*armstorage.BlobServicePropertiesProperties, error) {
+	args := m.Called()
+	return args.Get(0).(*armstorage.BlobServicePropertiesProperties), args.Error(1)
+}
+
+func (m *mockStorageAccount) GetEncryptionScope(name string) (*armstorage.EncryptionScope, error) {
+	args := m.Called(name)
+	return args.Get(0).(*armstorage.EncryptionScope), args.Error(1)
+}
+
+func TestGetEncryptionScope(t *testing.T) {
+	tests := []struct {
+		name          string
+		scope         *armstorage.EncryptionScope
+		err           error
+		expectedError string
+	}{
+		{
+			name: "enabled scope",
+			scope: &armstorage.EncryptionScope{
+				EncryptionScopeProperties: &armstorage.EncryptionScopeProperties{State: to.Ptr(armstorage.EncryptionScopeStateEnabled)},
+			},
+		},
+		{
+			name: "disabled scope",
+			scope: &armstorage.EncryptionScope{
+				EncryptionScopeProperties: &armstorage.EncryptionScopeProperties{State: to.Ptr(armstorage.EncryptionScopeStateDisabled)},
+			},
+			expectedError: "encryption scope my-scope is not enabled",
+		},
+		{
+			name:          "missing scope",
+			scope:         (*armstorage.EncryptionScope)(nil),
+			err:           &azcore.ResponseError{StatusCode: 404},
+			expectedError: "encryption scope my-scope not found in the storage account",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			account := new(mockStorageAccount)
+			account.On("GetEncryptionScope", "my-scope").Return(tc.scope, tc.err)
+
+			cpkScopeInfo, err := getEncryptionScope(logrus.New(), account, "my-scope")
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "my-scope", *cpkScopeInfo.EncryptionScope)
+		})
+	}
+
+	// the scope can't be checked without access to the management plane
+	cpkScopeInfo, err := getEncryptionScope(logrus.New(), nil, "my-scope")
+	require.NoError(t, err)
+	assert.Equal(t, "my-scope", *cpkScopeInfo.EncryptionScope)
+
+	// not configured
+	cpkScopeInfo, err = getEncryptionScope(logrus.New(), nil, "")
+	require.NoError(t, err)
+	assert.Nil(t, cpkScopeInfo)
+}
@@ -0,0 +1,206 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/tracing"
+	"github.com/pkg/errors"
+)
+
+// the operations of the object store that can be given a timeout
+const (
+	operationList     = "list"
+	operationGet      = "get"
+	operationPutBlock = "putBlock"
+	operationCommit   = "commit"
+	operationDelete   = "delete"
+	operationSAS      = "sas"
+)
+
+// the operations of the object store without timeout, their requests only complete when Azure
+// responds or the connection fails
+const (
+	operationSetTier        = "setTier"
+	operationUndelete       = "undelete"
+	operationPromoteVersion = "promoteVersion"
+)
+
+const (
+	listTimeoutConfigKey     = "listTimeout"
+	getTimeoutConfigKey      = "getTimeout"
+	putBlockTimeoutConfigKey = "putBlockTimeout"
+	commitTimeoutConfigKey   = "commitTimeout"
+	deleteTimeoutConfigKey   = "deleteTimeout"
+	sasTimeoutConfigKey      = "sasTimeout"
+)
+
+var operationTimeoutConfigKeys = map[string]string{
+	operationList:     listTimeoutConfigKey,
+	operationGet:      getTimeoutConfigKey,
+	operationPutBlock: putBlockTimeoutConfigKey,
+	operationCommit:   commitTimeoutConfigKey,
+	operationDelete:   deleteTimeoutConfigKey,
+	operationSAS:      sasTimeoutConfigKey,
+}
+
+// TimeoutError is returned when a request to Azure doesn't complete within the timeout
+// configured for its operation.
+type TimeoutError struct {
+	Operation string
+	Timeout   time.Duration
+	Err       error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s operation timed out after %s (the timeout set by config key %q)", e.Operation, e.Timeout, operationTimeoutConfigKeys[e.Operation])
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// operationTimeouts are the timeouts of the operations, operations without a timeout only
+// complete when Azure responds or the connection fails
+type operationTimeouts map[string]time.Duration
+
+func getOperationTimeouts(config map[string]string) (operationTimeouts, error) {
+	timeouts := operationTimeouts{}
+	for operation, key := range operationTimeoutConfigKeys {
+		val := config[key]
+		if val == "" {
+			continue
+		}
+
+		timeout, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse value %q for config key %q (expected a duration, e.g. 30s or 5m)", val, key)
+		}
+		if timeout <= 0 {
+			return nil, errors.Errorf("value %q for config key %q must be positive", val, key)
+		}
+		timeouts[operation] = timeout
+	}
+	return timeouts, nil
+}
+
+// context returns the context of a request of the operation, which expires with the timeout of the operation
+func (t operationTimeouts) context(parent context.Context, operation string) (context.Context, context.CancelFunc) {
+	if timeout := t[operation]; timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// check returns a TimeoutError in place of the error of a request whose context expired
+func (t operationTimeouts) check(ctx context.Context, operation string, err error) error {
+	if err != nil && t[operation] > 0 && errors.Is(context.Cause(ctx), context.DeadlineExceeded) {
+		return &TimeoutError{Operation: operation, Timeout: t[operation], Err: err}
+	}
+	return err
+}
+
+// requestOptions are the timeouts, the retry policy and the tracer of the requests of the object store
+type requestOptions struct {
+	timeouts operationTimeouts
+	retry    *retryPolicy
+	tracer   tracing.Tracer
+}
+
+// start starts a single request of the operation, traced with the name of the call of the
+// client. The SDK traces the attempts of the request under the span of the call. The returned
+// function ends the request with its error, which it returns as a TimeoutError when the request
+// timed out.
+func (r requestOptions) start(parent context.Context, operation, name string) (context.Context, func(error) error) {
+	// the blob clients don't start the spans of their calls themselves, which the SDK needs to
+	// trace their HTTP requests
+	ctx, endSpan := runtime.StartSpan(parent, name, r.tracer, nil)
+	ctx, cancel := r.timeouts.context(ctx, operation)
+	ctx = r.retry.context(ctx, operation)
+	return ctx, func(err error) error {
+		err = r.timeouts.check(ctx, operation, err)
+		endSpan(err)
+		cancel()
+		return err
+	}
+}
+
+// startDownload starts a download of the operation like start, except that the timeout of the
+// operation only applies until the response is received: a large body that is slow to read but
+// keeps progressing isn't cancelled. The returned received function must be called once the
+// response is received.
+func (r requestOptions) startDownload(parent context.Context, operation, name string) (context.Context, func(), func(error) error) {
+	ctx, endSpan := runtime.StartSpan(parent, name, r.tracer, nil)
+	ctx, cancel := context.WithCancelCause(ctx)
+	// a deadline can't be lifted once the response is received, the context is cancelled
+	// with the cause a deadline would have instead
+	received := func() {}
+	if timeout := r.timeouts[operation]; timeout > 0 {
+		timer := time.AfterFunc(timeout, func() { cancel(context.DeadlineExceeded) })
+		received = func() { timer.Stop() }
+	}
+	ctx = r.retry.context(ctx, operation)
+	return ctx, received, func(err error) error {
+		err = r.timeouts.check(ctx, operation, err)
+		endSpan(err)
+		received()
+		cancel(nil)
+		return err
+	}
+}
+
+// nextPage fetches the next page of a listing within the timeout of the list operation
+func nextPage[T any](ctx context.Context, requests requestOptions, pager *runtime.Pager[T]) (T, error) {
+	ctx, done := requests.start(ctx, operationList, "ContainerClient.ListBlobs")
+	page, err := pager.NextPage(ctx)
+	return page, done(err)
+}
+
+// timeoutReadCloser reads the body of a download, whose request must stay alive until the
+// body is read. The request is ended when the body is closed.
+type timeoutReadCloser struct {
+	body      io.ReadCloser
+	ctx       context.Context
+	timeouts  operationTimeouts
+	operation string
+	done      func(error) error
+	// the error the body failed to be read with, if any
+	err error
+}
+
+func (r *timeoutReadCloser) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	err = r.timeouts.check(r.ctx, r.operation, err)
+	if r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *timeoutReadCloser) Close() error {
+	err := r.body.Close()
+	r.done(r.err)
+	return err
+}
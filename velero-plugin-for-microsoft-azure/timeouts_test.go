@@ -0,0 +1,136 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOperationTimeouts(t *testing.T) {
+	timeouts, err := getOperationTimeouts(map[string]string{
+		listTimeoutConfigKey:   "30s",
+		commitTimeoutConfigKey: "5m",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, operationTimeouts{operationList: 30 * time.Second, operationCommit: 5 * time.Minute}, timeouts)
+
+	_, err = getOperationTimeouts(map[string]string{getTimeoutConfigKey: "10"})
+	assert.Error(t, err)
+	_, err = getOperationTimeouts(map[string]string{sasTimeoutConfigKey: "-1s"})
+	assert.Error(t, err)
+}
+
+// newStalledBlob returns a blob whose requests are answered by the handler
+func newStalledBlob(t *testing.T, timeouts operationTimeouts, handler http.HandlerFunc) *azureBlob {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := blockblob.NewClientWithNoCredential(server.URL+"/c/k", &blockblob.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}},
+	})
+	require.NoError(t, err)
+	return &azureBlob{ctx: context.Background(), container: "c", blob: "k", blobClient: client, requests: requestOptions{timeouts: timeouts, tracer: newTracingProvider().NewTracer(azblobModuleName, "")}}
+}
+
+func TestBlobOperationTimeout(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	b := newStalledBlob(t, operationTimeouts{operationPutBlock: 50 * time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	})
+
+	err := b.PutBlock("id", []byte("data"), nil)
+	var timeoutErr *TimeoutError
+	require.ErrorAs(t, err, &timeoutErr)
+	assert.Equal(t, operationPutBlock, timeoutErr.Operation)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, `putBlock operation timed out after 50ms (the timeout set by config key "putBlockTimeout")`, err.Error())
+}
+
+func TestGetTimeoutCoversResponse(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	b := newStalledBlob(t, operationTimeouts{operationGet: 100 * time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	})
+
+	_, _, err := b.Get(nil)
+	var timeoutErr *TimeoutError
+	require.ErrorAs(t, err, &timeoutErr)
+	assert.Equal(t, operationGet, timeoutErr.Operation)
+	assert.Equal(t, `get operation timed out after 100ms (the timeout set by config key "getTimeout")`, timeoutErr.Error())
+}
+
+func TestGetTimeoutDoesNotCoverBody(t *testing.T) {
+	b := newStalledBlob(t, operationTimeouts{operationGet: 100 * time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "8")
+		w.WriteHeader(http.StatusOK)
+		// the body keeps progressing past the timeout
+		for _, chunk := range []string{"da", "ta", "da", "ta"} {
+			_, _ = w.Write([]byte(chunk))
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+
+	body, _, err := b.Get(nil)
+	require.NoError(t, err)
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "datadata", string(data))
+}
+
+func TestOperationWithoutTimeout(t *testing.T) {
+	// the deadline of the operation isn't reported as the timeout of a config key
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	b := newStalledBlob(t, operationTimeouts{operationGet: time.Minute}, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	})
+	b.ctx = ctx
+
+	err := b.Undelete()
+	require.Error(t, err)
+	var timeoutErr *TimeoutError
+	assert.False(t, errors.As(err, &timeoutErr))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
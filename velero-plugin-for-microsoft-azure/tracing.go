@@ -0,0 +1,235 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/tracing"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName         = "github.com/vmware-tanzu/velero-plugin-for-microsoft-azure"
+	defaultServiceName = "velero-plugin-for-microsoft-azure"
+	// the spans of the calls of the blob clients are attributed to their module
+	azblobModuleName = "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	// the standard environment variables of the OTLP exporters, the exporters read the others
+	// themselves, e.g. the headers, compression and TLS settings
+	// ref. https://opentelemetry.io/docs/specs/otel/protocol/exporter/
+	otlpEndpointEnvVar       = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	otlpTracesEndpointEnvVar = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+	otlpProtocolEnvVar       = "OTEL_EXPORTER_OTLP_PROTOCOL"
+	otlpTracesProtocolEnvVar = "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"
+
+	// how long the spans still buffered at exit are given to be exported
+	tracingShutdownTimeout = 5 * time.Second
+)
+
+// setupTracing exports the spans over OTLP when an endpoint is configured, the spans are
+// no-ops otherwise. The returned function exports the spans still buffered and stops the
+// export, it's meant to be called when the plugin exits. The plugin is also terminated by
+// SIGTERM, the buffered spans are exported before it is.
+func setupTracing(log logrus.FieldLogger) func() {
+	if os.Getenv(otlpTracesEndpointEnvVar) == "" && os.Getenv(otlpEndpointEnvVar) == "" {
+		return func() {}
+	}
+
+	exporter, err := newOTLPExporter(context.Background())
+	if err != nil {
+		log.WithError(err).Warn("Error creating the exporter of the traces, the traces aren't exported")
+		return func() {}
+	}
+
+	// the service name and the attributes of the environment take precedence over the default name
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(attribute.String("service.name", defaultServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		log.WithError(err).Warn("Error detecting the resource of the traces")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(time.Second)),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.WithError(err).Warn("Error exporting traces")
+	}))
+	log.Info("Exporting traces over OTLP")
+
+	var once sync.Once
+	shutdown := func() {
+		once.Do(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+			defer cancel()
+			if err := provider.Shutdown(ctx); err != nil {
+				log.WithError(err).Warn("Error exporting the last traces")
+			}
+		})
+	}
+
+	terminated := make(chan os.Signal, 1)
+	signal.Notify(terminated, syscall.SIGTERM)
+	go func() {
+		<-terminated
+		shutdown()
+		// terminate the plugin as SIGTERM would have without the handler
+		signal.Stop(terminated)
+		if process, err := os.FindProcess(os.Getpid()); err != nil || process.Signal(syscall.SIGTERM) != nil {
+			os.Exit(1)
+		}
+	}()
+	return shutdown
+}
+
+// newOTLPExporter returns the OTLP exporter of the protocol set by the environment, the HTTP
+// exporter sending protobuf by default as the OTLP specification recommends
+func newOTLPExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	protocol := os.Getenv(otlpTracesProtocolEnvVar)
+	if protocol == "" {
+		protocol = os.Getenv(otlpProtocolEnvVar)
+	}
+	switch protocol {
+	case "", "http/protobuf":
+		return otlptracehttp.New(ctx)
+	case "grpc":
+		return otlptracegrpc.New(ctx)
+	default:
+		return nil, errors.Errorf("unsupported OTLP protocol %q, the supported protocols are http/protobuf and grpc", protocol)
+	}
+}
+
+// startSpan starts a span of the plugin
+func startSpan(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, options...)
+}
+
+// endSpan ends the span, recording the error of its operation if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startObjectStoreOperation starts an operation of the object store. The returned function
+// records the duration and the error of the operation in its span and in the metrics, it's
+// meant to be deferred with the named error result of the operation.
+func startObjectStoreOperation(operation string, attrs ...attribute.KeyValue) (context.Context, func(*error)) {
+	start := time.Now()
+	ctx, span := startSpan(context.Background(), "ObjectStore."+operation, trace.WithAttributes(attrs...))
+	return ctx, func(err *error) {
+		observeObjectStoreOperation(operation, start, err)
+		endSpan(span, *err)
+	}
+}
+
+// startVolumeSnapshotterOperation starts an operation of the volume snapshotter, like
+// startObjectStoreOperation
+func startVolumeSnapshotterOperation(operation string, attrs ...attribute.KeyValue) (context.Context, func(*error)) {
+	start := time.Now()
+	ctx, span := startSpan(context.Background(), "VolumeSnapshotter."+operation, trace.WithAttributes(attrs...))
+	return ctx, func(err *error) {
+		observeVolumeSnapshotterOperation(operation, start, err)
+		endSpan(span, *err)
+	}
+}
+
+// newTracingProvider returns the tracing provider of the clients of the Azure SDK, which
+// creates spans for their calls and requests under the spans of the plugin
+func newTracingProvider() tracing.Provider {
+	return tracing.NewProvider(func(name, version string) tracing.Tracer {
+		tracer := otel.Tracer(name, trace.WithInstrumentationVersion(version))
+		return tracing.NewTracer(func(ctx context.Context, spanName string, options *tracing.SpanOptions) (context.Context, tracing.Span) {
+			var startOptions []trace.SpanStartOption
+			if options != nil {
+				startOptions = append(startOptions,
+					trace.WithSpanKind(trace.SpanKind(options.Kind)),
+					trace.WithAttributes(convertAttributes(options.Attributes)...),
+				)
+			}
+			ctx, span := tracer.Start(ctx, spanName, startOptions...)
+			return ctx, newTracingSpan(span)
+		}, &tracing.TracerOptions{
+			SpanFromContext: func(ctx context.Context) tracing.Span {
+				return newTracingSpan(trace.SpanFromContext(ctx))
+			},
+		})
+	}, nil)
+}
+
+func newTracingSpan(span trace.Span) tracing.Span {
+	return tracing.NewSpan(tracing.SpanImpl{
+		End: func() { span.End() },
+		SetAttributes: func(attrs ...tracing.Attribute) {
+			span.SetAttributes(convertAttributes(attrs)...)
+		},
+		AddEvent: func(name string, attrs ...tracing.Attribute) {
+			span.AddEvent(name, trace.WithAttributes(convertAttributes(attrs)...))
+		},
+		SetStatus: func(code tracing.SpanStatus, description string) {
+			switch code {
+			case tracing.SpanStatusError:
+				span.SetStatus(codes.Error, description)
+			case tracing.SpanStatusOK:
+				span.SetStatus(codes.Ok, description)
+			}
+		},
+	})
+}
+
+func convertAttributes(attrs []tracing.Attribute) []attribute.KeyValue {
+	converted := make([]attribute.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		switch value := attr.Value.(type) {
+		case string:
+			converted = append(converted, attribute.String(attr.Key, value))
+		case int:
+			converted = append(converted, attribute.Int(attr.Key, value))
+		case int64:
+			converted = append(converted, attribute.Int64(attr.Key, value))
+		case float64:
+			converted = append(converted, attribute.Float64(attr.Key, value))
+		case bool:
+			converted = append(converted, attribute.Bool(attr.Key, value))
+		default:
+			converted = append(converted, attribute.String(attr.Key, fmt.Sprintf("%v", value)))
+		}
+	}
+	return converted
+}
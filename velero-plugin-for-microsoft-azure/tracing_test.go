@@ -0,0 +1,140 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/tracing"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans records the spans ended during the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestObjectStoreOperationSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	b := newStalledBlob(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-error-code", "InternalError")
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	ctx, end := startObjectStoreOperation("ObjectExists", attribute.String("bucket", "c"), attribute.String("key", "k"))
+	b.ctx = ctx
+	_, err := b.GetProperties(nil)
+	end(&err)
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	request, call, operation := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "ObjectStore.ObjectExists", operation.Name())
+	assert.Contains(t, operation.Attributes(), attribute.String("key", "k"))
+	assert.Equal(t, codes.Error, operation.Status().Code)
+
+	// the call of the client and its HTTP request are traced by the SDK
+	assert.Equal(t, "BlobClient.GetProperties", call.Name())
+	assert.Equal(t, azblobModuleName, call.InstrumentationScope().Name)
+	assert.Equal(t, operation.SpanContext().SpanID(), call.Parent().SpanID())
+	assert.Equal(t, codes.Error, call.Status().Code)
+
+	assert.Equal(t, "HTTP HEAD", request.Name())
+	assert.Equal(t, trace.SpanKindClient, request.SpanKind())
+	assert.Equal(t, call.SpanContext().SpanID(), request.Parent().SpanID())
+	assert.Contains(t, request.Attributes(), attribute.Int("http.status_code", http.StatusInternalServerError))
+}
+
+func TestTracingProvider(t *testing.T) {
+	recorder := recordSpans(t)
+	tracer := newTracingProvider().NewTracer("armcompute", "v4")
+
+	ctx, operation := startSpan(context.Background(), "VolumeSnapshotter.CreateSnapshot")
+	_, span := tracer.Start(ctx, "DisksClient.Get", &tracing.SpanOptions{
+		Kind:       tracing.SpanKindClient,
+		Attributes: []tracing.Attribute{{Key: "az.namespace", Value: "Microsoft.Compute"}, {Key: "retries", Value: 2}},
+	})
+	span.AddEvent("retry")
+	span.SetStatus(tracing.SpanStatusError, "not found")
+	span.End()
+	operation.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "DisksClient.Get", spans[0].Name())
+	assert.Equal(t, "armcompute", spans[0].InstrumentationScope().Name)
+	assert.Equal(t, trace.SpanKindClient, spans[0].SpanKind())
+	assert.Equal(t, []attribute.KeyValue{attribute.String("az.namespace", "Microsoft.Compute"), attribute.Int("retries", 2)}, spans[0].Attributes())
+	assert.Equal(t, sdktrace.Status{Code: codes.Error, Description: "not found"}, spans[0].Status())
+	assert.Equal(t, "retry", spans[0].Events()[0].Name)
+	assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+}
+
+func TestSetupTracing(t *testing.T) {
+	exported := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exported <- r
+	}))
+	defer server.Close()
+	t.Setenv(otlpEndpointEnvVar, server.URL)
+	t.Setenv(otlpTracesEndpointEnvVar, "")
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	shutdown := setupTracing(logrus.New())
+	_, span := startSpan(context.Background(), "ObjectStore.PutObject")
+	span.End()
+	// the buffered span is exported on shutdown
+	shutdown()
+
+	select {
+	case r := <-exported:
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+	default:
+		t.Fatal("the span wasn't exported")
+	}
+}
+
+func TestNewOTLPExporter(t *testing.T) {
+	for _, protocol := range []string{"", "http/protobuf", "grpc"} {
+		t.Setenv(otlpProtocolEnvVar, protocol)
+		_, err := newOTLPExporter(context.Background())
+		assert.NoError(t, err, protocol)
+	}
+
+	t.Setenv(otlpTracesProtocolEnvVar, "http/json")
+	_, err := newOTLPExporter(context.Background())
+	assert.ErrorContains(t, err, `unsupported OTLP protocol "http/json"`)
+}
@@ -0,0 +1,229 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	azcontainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const deleteAllVersionsConfigKey = "deleteAllVersions"
+
+// dataProtection is the blob versioning and soft delete configuration of the storage account,
+// which decide whether a deleted object can still be recovered
+type dataProtection struct {
+	versioning bool
+	// the number of days deleted objects are retained for, 0 when soft delete is disabled
+	softDeleteRetentionDays int32
+}
+
+// the data protection of storage accounts doesn't change often and the object store is
+// initialized for every operation, so it's only read once per storage account, whether or not
+// it could be read: the management plane may not be reachable with the credential of the
+// object store, e.g. a storage account key
+var dataProtectionCache sync.Map
+
+// getDataProtection returns nil when the data protection of the storage account is unknown,
+// and whether it was read rather than cached
+func getDataProtection(log logrus.FieldLogger, account storageAccount) (*dataProtection, bool) {
+	if account == nil {
+		return nil, false
+	}
+	if cached, ok := dataProtectionCache.Load(account.ID()); ok {
+		return cached.(*dataProtection), false
+	}
+
+	props, err := account.GetBlobServiceProperties()
+	if err != nil {
+		log.WithError(err).Debug("Unable to get the blob service properties of the storage account, versioning and soft delete aren't detected")
+		dataProtectionCache.Store(account.ID(), (*dataProtection)(nil))
+		return nil, true
+	}
+
+	result := &dataProtection{}
+	if props.IsVersioningEnabled != nil {
+		result.versioning = *props.IsVersioningEnabled
+	}
+	if policy := props.DeleteRetentionPolicy; policy != nil && policy.Enabled != nil && *policy.Enabled && policy.Days != nil {
+		result.softDeleteRetentionDays = *policy.Days
+	}
+	dataProtectionCache.Store(account.ID(), result)
+	return result, true
+}
+
+// logDataProtection logs how the deleted objects can be recovered. Unless always is set, it's
+// only logged when the data protection of the storage account is first read, rather than by
+// every initialization of the object store. The management plane being unreachable doesn't
+// fail the operation.
+func (o *ObjectStore) logDataProtection(always bool) {
+	if o.storageAccount == nil {
+		return
+	}
+	account, err := o.storageAccount()
+	if err != nil {
+		o.log.WithError(err).Debug("Unable to access the management plane of the storage account, versioning and soft delete aren't detected")
+		return
+	}
+	protection, read := getDataProtection(o.log, account)
+	if protection == nil || !(read || always) {
+		return
+	}
+
+	if protection.versioning && !o.deleteAllVersions {
+		o.log.Infof("Blob versioning is enabled on the storage account, the previous versions of deleted objects are kept unless %s is set", deleteAllVersionsConfigKey)
+	}
+	if protection.softDeleteRetentionDays > 0 {
+		o.log.Infof("Soft delete is enabled on the storage account, deleted objects can be recovered for %d days", protection.softDeleteRetentionDays)
+	}
+	if !protection.versioning && protection.softDeleteRetentionDays == 0 {
+		o.log.Warn("Neither blob versioning nor soft delete is enabled on the storage account, deleted objects can't be recovered")
+	}
+}
+
+// deleteVersions deletes the previous versions of an object, which are kept when blob
+// versioning is enabled
+func (o *ObjectStore) deleteVersions(ctx context.Context, bucket, key string) error {
+	container := o.containerGetter.getContainer(ctx, bucket)
+	params := azcontainer.ListBlobsFlatOptions{
+		Prefix:  &key,
+		Include: azcontainer.ListBlobsInclude{Versions: true},
+	}
+
+	blob := o.blobGetter.getBlob(ctx, bucket, key)
+	pager := container.ListBlobs(&params)
+	for pager.More() {
+		page, err := nextPage(ctx, o.requests, pager)
+		if err != nil {
+			return errors.Wrapf(err, "error listing the versions of object %s", key)
+		}
+
+		for _, item := range page.ListBlobsFlatSegmentResponse.Segment.BlobItems {
+			if *item.Name != key || item.VersionID == nil {
+				continue
+			}
+			o.log.Debugf("Deleting version %s of object %s", *item.VersionID, key)
+			if err := blob.DeleteVersion(*item.VersionID); err != nil {
+				return errors.Wrapf(o.checkImmutability(blob, key, err), "error deleting version %s of object %s", *item.VersionID, key)
+			}
+		}
+	}
+	return nil
+}
+
+// deletedObject is an object that was deleted but can still be recovered, either from its
+// latest version when versioning is enabled, or from its soft-deleted state
+type deletedObject struct {
+	Key       string
+	VersionID string
+}
+
+// ListDeleted returns the objects under the prefix that are deleted and can be recovered
+func (o *ObjectStore) ListDeleted(bucket, prefix string) (_ []deletedObject, err error) {
+	ctx, end := startObjectStoreOperation("ListDeleted", attribute.String("bucket", bucket), attribute.String("prefix", prefix))
+	defer end(&err)
+	o.logDataProtection(true)
+	return o.listDeleted(ctx, bucket, prefix)
+}
+
+func (o *ObjectStore) listDeleted(ctx context.Context, bucket, prefix string) ([]deletedObject, error) {
+	container := o.containerGetter.getContainer(ctx, bucket)
+	params := azcontainer.ListBlobsFlatOptions{
+		Prefix:  &prefix,
+		Include: azcontainer.ListBlobsInclude{Deleted: true, Versions: true},
+	}
+
+	// the listing is ordered by name, and the versions of a blob by version ID, so the
+	// entries of each object are consecutive and its latest version comes last
+	var (
+		deleted     []deletedObject
+		current     string
+		hasCurrent  bool
+		softDeleted bool
+		latest      string
+	)
+	flush := func() {
+		switch {
+		case current == "" || hasCurrent:
+		case latest != "":
+			deleted = append(deleted, deletedObject{Key: current, VersionID: latest})
+		case softDeleted:
+			deleted = append(deleted, deletedObject{Key: current})
+		}
+	}
+
+	pager := container.ListBlobs(&params)
+	for pager.More() {
+		page, err := nextPage(ctx, o.requests, pager)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for _, item := range page.ListBlobsFlatSegmentResponse.Segment.BlobItems {
+			if *item.Name != current {
+				flush()
+				current, hasCurrent, softDeleted, latest = *item.Name, false, false, ""
+			}
+
+			isDeleted := item.Deleted != nil && *item.Deleted
+			switch {
+			case item.VersionID == nil && isDeleted:
+				softDeleted = true
+			case item.VersionID == nil || (item.IsCurrentVersion != nil && *item.IsCurrentVersion):
+				hasCurrent = true
+			case !isDeleted:
+				latest = *item.VersionID
+			}
+		}
+	}
+	flush()
+
+	return deleted, nil
+}
+
+// UndeletePrefix recovers the deleted objects under the prefix, so that a backup deleted
+// by mistake can be restored
+func (o *ObjectStore) UndeletePrefix(bucket, prefix string) (err error) {
+	ctx, end := startObjectStoreOperation("UndeletePrefix", attribute.String("bucket", bucket), attribute.String("prefix", prefix))
+	defer end(&err)
+	o.logDataProtection(true)
+	deleted, err := o.listDeleted(ctx, bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range deleted {
+		blob := o.blobGetter.getBlob(ctx, bucket, object.Key)
+		if object.VersionID != "" {
+			o.log.Debugf("Restoring object %s from version %s", object.Key, object.VersionID)
+			err = blob.PromoteVersion(object.VersionID)
+		} else {
+			o.log.Debugf("Undeleting object %s", object.Key)
+			err = blob.Undelete()
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error recovering object %s", object.Key)
+		}
+	}
+
+	o.log.Infof("Recovered %d deleted objects under prefix %q", len(deleted), prefix)
+	return nil
+}
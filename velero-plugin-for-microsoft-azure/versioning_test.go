@@ -0,0 +1,159 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	azcontainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDataProtection(t *testing.T) {
+	t.Cleanup(dataProtectionCache.Clear)
+	protection, _ := getDataProtection(logrus.New(), nil)
+	assert.Nil(t, protection)
+
+	account := &mockStorageAccount{id: "versioning-and-soft-delete"}
+	account.On("GetBlobServiceProperties").Return(&armstorage.BlobServicePropertiesProperties{
+		IsVersioningEnabled:   to.Ptr(true),
+		DeleteRetentionPolicy: &armstorage.DeleteRetentionPolicy{Enabled: to.Ptr(true), Days: to.Ptr(int32(7))},
+	}, nil).Once()
+
+	expected := &dataProtection{versioning: true, softDeleteRetentionDays: 7}
+	protection, read := getDataProtection(logrus.New(), account)
+	assert.Equal(t, expected, protection)
+	assert.True(t, read)
+	// read once per storage account
+	protection, read = getDataProtection(logrus.New(), account)
+	assert.Equal(t, expected, protection)
+	assert.False(t, read)
+	account.AssertExpectations(t)
+
+	// the management plane isn't reachable, which is only tried once as well
+	unreachable := &mockStorageAccount{id: "unreachable"}
+	unreachable.On("GetBlobServiceProperties").Return((*armstorage.BlobServicePropertiesProperties)(nil), errors.New("forbidden")).Once()
+	protection, _ = getDataProtection(logrus.New(), unreachable)
+	assert.Nil(t, protection)
+	protection, read = getDataProtection(logrus.New(), unreachable)
+	assert.Nil(t, protection)
+	assert.False(t, read)
+	unreachable.AssertExpectations(t)
+}
+
+func TestLogDataProtection(t *testing.T) {
+	t.Cleanup(dataProtectionCache.Clear)
+	account := &mockStorageAccount{id: "soft-delete"}
+	account.On("GetBlobServiceProperties").Return(&armstorage.BlobServicePropertiesProperties{
+		DeleteRetentionPolicy: &armstorage.DeleteRetentionPolicy{Enabled: to.Ptr(true), Days: to.Ptr(int32(7))},
+	}, nil).Once()
+
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	o := &ObjectStore{log: logger, storageAccount: func() (storageAccount, error) { return account, nil }}
+
+	// logged when first read and by the commands, not by every initialization
+	o.logDataProtection(false)
+	o.logDataProtection(false)
+	o.logDataProtection(true)
+	require.Len(t, hook.AllEntries(), 2)
+	assert.Equal(t, "Soft delete is enabled on the storage account, deleted objects can be recovered for 7 days", hook.LastEntry().Message)
+
+	// an unreachable management plane is only logged at debug level
+	hook.Reset()
+	o.storageAccount = func() (storageAccount, error) { return nil, errors.New("no credential") }
+	o.logDataProtection(true)
+	require.Len(t, hook.AllEntries(), 1)
+	assert.Equal(t, logrus.DebugLevel, hook.LastEntry().Level)
+}
+
+func TestDeleteObjectAllVersions(t *testing.T) {
+	containerGetter := new(mockContainerGetter)
+	blobGetter := new(mockBlobGetter)
+	blob := new(mockBlob)
+	defer blob.AssertExpectations(t)
+
+	containerGetter.On("getContainer", "b").Return(&fakeContainer{
+		items: []*azcontainer.BlobItem{
+			{Name: to.Ptr("backups/b1/k"), VersionID: to.Ptr("v1")},
+			{Name: to.Ptr("backups/b1/k"), VersionID: to.Ptr("v2")},
+			{Name: to.Ptr("backups/b1/k2"), VersionID: to.Ptr("v3")},
+		},
+	})
+	blobGetter.On("getBlob", "b", "backups/b1/k").Return(blob)
+	blob.On("Delete", (*azblob.DeleteBlobOptions)(nil)).Return(nil).Once()
+	blob.On("DeleteVersion", "v1").Return(nil).Once()
+	blob.On("DeleteVersion", "v2").Return(nil).Once()
+
+	o := &ObjectStore{
+		log:               logrus.New(),
+		containerGetter:   containerGetter,
+		blobGetter:        blobGetter,
+		deleteAllVersions: true,
+	}
+	require.NoError(t, o.DeleteObject("b", "backups/b1/k"))
+}
+
+func TestUndeletePrefix(t *testing.T) {
+	containerGetter := new(mockContainerGetter)
+	blobGetter := new(mockBlobGetter)
+	versioned := new(mockBlob)
+	softDeleted := new(mockBlob)
+	defer versioned.AssertExpectations(t)
+	defer softDeleted.AssertExpectations(t)
+
+	containerGetter.On("getContainer", "b").Return(&fakeContainer{
+		items: []*azcontainer.BlobItem{
+			// deleted with versioning enabled, the latest version is restored
+			{Name: to.Ptr("backups/b1/deleted"), VersionID: to.Ptr("v1")},
+			{Name: to.Ptr("backups/b1/deleted"), VersionID: to.Ptr("v2")},
+			{Name: to.Ptr("backups/b1/deleted"), VersionID: to.Ptr("v3"), Deleted: to.Ptr(true)},
+			// not deleted
+			{Name: to.Ptr("backups/b1/live"), VersionID: to.Ptr("v1")},
+			{Name: to.Ptr("backups/b1/live"), VersionID: to.Ptr("v2"), IsCurrentVersion: to.Ptr(true)},
+			{Name: to.Ptr("backups/b1/unversioned")},
+			// soft-deleted without versioning
+			{Name: to.Ptr("backups/b1/soft-deleted"), Deleted: to.Ptr(true)},
+		},
+	})
+
+	o := &ObjectStore{
+		log:             logrus.New(),
+		containerGetter: containerGetter,
+		blobGetter:      blobGetter,
+	}
+
+	deleted, err := o.ListDeleted("b", "backups/b1/")
+	require.NoError(t, err)
+	assert.Equal(t, []deletedObject{
+		{Key: "backups/b1/deleted", VersionID: "v2"},
+		{Key: "backups/b1/soft-deleted"},
+	}, deleted)
+
+	blobGetter.On("getBlob", "b", "backups/b1/deleted").Return(versioned)
+	blobGetter.On("getBlob", "b", "backups/b1/soft-deleted").Return(softDeleted)
+	versioned.On("PromoteVersion", "v2").Return(nil).Once()
+	softDeleted.On("Undelete").Return(nil).Once()
+	require.NoError(t, o.UndeletePrefix("b", "backups/b1/"))
+}
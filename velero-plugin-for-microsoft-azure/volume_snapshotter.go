@@ -19,10 +19,12 @@ package main
 import (
 	"context"
 	"fmt"
+	"maps"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
@@ -35,9 +37,11 @@ import (
 	"github.com/sirupsen/logrus"
 	veleroplugin "github.com/vmware-tanzu/velero/pkg/plugin/framework"
 	"github.com/vmware-tanzu/velero/pkg/util/azure"
+	"go.opentelemetry.io/otel/attribute"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	"sigs.k8s.io/azuredisk-csi-driver/pkg/util"
 )
 
@@ -53,34 +57,78 @@ const (
 
 	snapshotsResource = "snapshots"
 	disksResource     = "disks"
+	diskResourceType  = "Microsoft.Compute/" + disksResource
 
 	diskCSIDriver = "disk.csi.azure.com"
-	pollingDelay  = 5 * time.Second
 )
 
+// pollingDelay is the delay between the polls of the long-running operations and of the
+// completion of the snapshots
+var pollingDelay = 5 * time.Second
+
 type VolumeSnapshotter struct {
-	log                logrus.FieldLogger
-	disks              *armcompute.DisksClient
+	log logrus.FieldLogger
+	// the clients of the disks by subscription, created on demand since the disks can be in
+	// any subscription the credentials have access to
+	disks              map[string]*armcompute.DisksClient
+	disksLock          sync.Mutex
+	credential         azcore.TokenCredential
+	clientOptions      *arm.ClientOptions
 	snaps              *armcompute.SnapshotsClient
 	disksSubscription  string
 	snapsSubscription  string
 	disksResourceGroup string
 	snapsResourceGroup string
 	snapsIncremental   *bool
-	apiTimeout         time.Duration
-	snapsTags          map[string]string
+	// whether to wait for the data of the incremental snapshots to be copied
+	snapsWaitForCompletion bool
+	apiTimeout             time.Duration
+	snapsTags              map[string]string
+	// the regions the snapshots are copied to
+	snapsCopyTargets []snapshotCopyTarget
+	// the region of the cluster the volumes are restored in, if configured
+	restoreRegion string
+	// whether to copy the snapshots in other regions to the restore region before restoring them
+	copyToRestoreRegion bool
+	// the performance settings of the restored disks, by SKU, overriding those of the backed up disks
+	diskPerformanceOverrides map[armcompute.DiskStorageAccountTypes]diskPerformance
+	retry                    *retryPolicy
+	rateLimiter              *armRateLimiter
 }
 
 type snapshotIdentifier struct {
 	subscription  string
 	resourceGroup string
 	name          string
+	// the copies of the snapshot in other regions
+	copies []snapshotCopy
 }
 
 func (si *snapshotIdentifier) String() string {
 	return getComputeResourceName(si.subscription, si.resourceGroup, snapshotsResource, si.name)
 }
 
+// fullName returns the snapshot ID returned to Velero, which is the ID of the snapshot followed
+// by the regions and the IDs of its copies, e.g. "<ID>;westus=<ID of the copy>"
+func (si *snapshotIdentifier) fullName() string {
+	name := si.String()
+	for _, c := range si.copies {
+		name += snapshotCopySeparator + c.region + "=" + c.id.String()
+	}
+	return name
+}
+
+// diskIdentifier identifies the managed disk of a volume ID
+type diskIdentifier struct {
+	subscription  string
+	resourceGroup string
+	name          string
+}
+
+func (di *diskIdentifier) String() string {
+	return getComputeResourceName(di.subscription, di.resourceGroup, disksResource, di.name)
+}
+
 func newVolumeSnapshotter(logger logrus.FieldLogger) *VolumeSnapshotter {
 	return &VolumeSnapshotter{log: logger}
 }
@@ -91,7 +139,24 @@ func (b *VolumeSnapshotter) Init(config map[string]string) error {
 		vslConfigKeyAPITimeout,
 		vslConfigKeySubscriptionID,
 		vslConfigKeyIncremental,
+		vslConfigKeyWaitForSnapshotCompletion,
 		vslConfigKeyTags,
+		vslConfigKeyCopyRegions,
+		vslConfigKeyRestoreRegion,
+		vslConfigKeyCopyToRestoreRegion,
+		vslConfigKeyDiskIOPSReadWrite,
+		vslConfigKeyDiskMBpsReadWrite,
+		vslConfigKeyDiskTier,
+		vslConfigKeyDiskBurstingEnabled,
+		maxRetriesConfigKey,
+		retryDelayConfigKey,
+		maxRetryDelayConfigKey,
+		retryStatusCodesConfigKey,
+		tryTimeoutConfigKey,
+		vslConfigKeyReadRequestsPerSecond,
+		vslConfigKeyReadBurst,
+		vslConfigKeyWriteRequestsPerSecond,
+		vslConfigKeyWriteBurst,
 		credentialsFileConfigKey,
 	); err != nil {
 		return err
@@ -129,6 +194,13 @@ func (b *VolumeSnapshotter) Init(config map[string]string) error {
 		b.snapsIncremental = &parseIncremental
 	}
 
+	if val := config[vslConfigKeyWaitForSnapshotCompletion]; val != "" {
+		b.snapsWaitForCompletion, err = strconv.ParseBool(val)
+		if err != nil {
+			return errors.Wrapf(err, "unable to parse value %q for config key %q (expected a boolean value)", val, vslConfigKeyWaitForSnapshotCompletion)
+		}
+	}
+
 	if val := config[vslConfigKeyTags]; val != "" {
 		b.snapsTags, err = util.ConvertTagsToMap(val)
 		if err != nil {
@@ -136,21 +208,61 @@ func (b *VolumeSnapshotter) Init(config map[string]string) error {
 		}
 	}
 
+	if val := config[vslConfigKeyCopyRegions]; val != "" {
+		// only incremental snapshots can be copied to other regions
+		if b.snapsIncremental == nil || !*b.snapsIncremental {
+			return errors.Errorf("config key %q requires config key %q to be true", vslConfigKeyCopyRegions, vslConfigKeyIncremental)
+		}
+		if b.snapsCopyTargets, err = parseSnapshotCopyTargets(val, b.snapsResourceGroup); err != nil {
+			return err
+		}
+	}
+	b.restoreRegion = normalizeRegion(config[vslConfigKeyRestoreRegion])
+	if val := config[vslConfigKeyCopyToRestoreRegion]; val != "" {
+		b.copyToRestoreRegion, err = strconv.ParseBool(val)
+		if err != nil {
+			return errors.Wrapf(err, "unable to parse value %q for config key %q (expected a boolean value)", val, vslConfigKeyCopyToRestoreRegion)
+		}
+		if b.copyToRestoreRegion && b.restoreRegion == "" {
+			return errors.Errorf("config key %q requires config key %q to be set", vslConfigKeyCopyToRestoreRegion, vslConfigKeyRestoreRegion)
+		}
+	}
+
+	if b.diskPerformanceOverrides, err = parseDiskPerformanceOverrides(config); err != nil {
+		return err
+	}
+
+	if b.retry, err = getRetryPolicy(b.log, config); err != nil {
+		return err
+	}
+	// ARM throttles the requests of a subscription past its limits, the throttled requests
+	// are retried after the delay of the Retry-After header
+	b.retry.retryStatusCode(http.StatusTooManyRequests)
+	if b.rateLimiter, err = getARMRateLimiter(config); err != nil {
+		return err
+	}
+
 	clientOptions, err := azure.GetClientOptions(config, creds)
 	if err != nil {
 		return err
 	}
-	credential, err := azure.NewCredential(creds, clientOptions)
+	// the token requests of the credential go to AAD, they aren't retried, limited nor traced as
+	// requests to ARM
+	b.credential, err = azure.NewCredential(creds, clientOptions)
 	if err != nil {
 		return err
 	}
+	clientOptions.PerCallPolicies = append(clientOptions.PerCallPolicies, b.retry)
+	// the clients share the rate limiter, so that their requests are limited together
+	clientOptions.PerRetryPolicies = append(clientOptions.PerRetryPolicies, b.rateLimiter, retryLogPolicy{})
+	clientOptions.TracingProvider = newTracingProvider()
+	b.clientOptions = &arm.ClientOptions{ClientOptions: clientOptions}
 
-	b.disks, err = armcompute.NewDisksClient(b.disksSubscription, credential, &arm.ClientOptions{ClientOptions: clientOptions})
-	if err != nil {
-		return errors.Wrap(err, "error creating disk client")
+	if _, err := b.getDisksClient(b.disksSubscription); err != nil {
+		return err
 	}
 
-	b.snaps, err = armcompute.NewSnapshotsClient(b.snapsSubscription, credential, &arm.ClientOptions{ClientOptions: clientOptions})
+	b.snaps, err = armcompute.NewSnapshotsClient(b.snapsSubscription, b.credential, b.clientOptions)
 	if err != nil {
 		return errors.Wrap(err, "error creating snapshot client")
 	}
@@ -158,19 +270,48 @@ func (b *VolumeSnapshotter) Init(config map[string]string) error {
 	return nil
 }
 
-func (b *VolumeSnapshotter) CreateVolumeFromSnapshot(snapshotID, volumeType, volumeAZ string, iops *int64) (string, error) {
+func (b *VolumeSnapshotter) CreateVolumeFromSnapshot(snapshotID, volumeType, volumeAZ string, iops *int64) (_ string, err error) {
+	ctx, end := startVolumeSnapshotterOperation("CreateVolumeFromSnapshot", attribute.String("snapshotID", snapshotID), attribute.String("volumeType", volumeType))
+	defer end(&err)
+	ctx = withOperation(ctx, "CreateVolumeFromSnapshot")
 	snapshotIdentifier, err := parseFullSnapshotName(snapshotID)
 	diskStorageAccountType := armcompute.DiskStorageAccountTypes(volumeType)
 	if err != nil {
 		return "", err
 	}
+	// restore from the copy of the snapshot in the region of the cluster, if there is one
+	if snapshotCopy := snapshotIdentifier.copyIn(b.restoreRegion); snapshotCopy != nil {
+		b.log.Infof("Restoring from the copy of the snapshot in region %s", b.restoreRegion)
+		snapshotIdentifier = snapshotCopy
+		// the data of the copy may still be being copied
+		if err := b.waitForSnapshotCompletion(ctx, snapshotIdentifier.resourceGroup, snapshotIdentifier.name); err != nil {
+			return "", err
+		}
+	}
 
 	// Lookup snapshot info for its Location & Tags so we can apply them to the volume
-	snapshotInfo, err := b.snaps.Get(context.TODO(), snapshotIdentifier.resourceGroup, snapshotIdentifier.name, nil)
+	snapshotInfo, err := b.snaps.Get(ctx, snapshotIdentifier.resourceGroup, snapshotIdentifier.name, nil)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
 
+	if location := normalizeRegion(deref(snapshotInfo.Location)); b.restoreRegion != "" && location != b.restoreRegion {
+		if !b.copyToRestoreRegion {
+			b.log.Warnf("Snapshot %s is in region %s, the volume is restored there instead of in region %s", snapshotIdentifier.name, location, b.restoreRegion)
+		} else {
+			snapshotCopy, err := b.copySnapshotToRegion(ctx, snapshotIdentifier, snapshotInfo.Snapshot, b.restoreRegion)
+			if snapshotCopy != nil {
+				// the copy is only needed until the disk is created from it
+				defer b.deleteIntermediateSnapshot(ctx, snapshotCopy)
+			}
+			if err != nil {
+				return "", err
+			}
+			snapshotIdentifier = snapshotCopy
+			snapshotInfo.Location = to.Ptr(b.restoreRegion)
+		}
+	}
+
 	uid, err := uuid.NewV4()
 	if err != nil {
 		return "", errors.WithStack(err)
@@ -189,32 +330,57 @@ func (b *VolumeSnapshotter) CreateVolumeFromSnapshot(snapshotID, volumeType, vol
 		SKU: &armcompute.DiskSKU{
 			Name: to.Ptr(diskStorageAccountType),
 		},
-		Tags: snapshotInfo.Tags,
+		Tags: withoutDiskPerformanceTags(snapshotInfo.Tags),
+	}
+	// restore the performance settings of the backed up disk, the IOPS recorded by Velero taking
+	// precedence over the tags of snapshots taken before, unless they're overridden for the SKU
+	perf := getDiskPerformanceFromTags(snapshotInfo.Tags)
+	if iops != nil {
+		perf.iopsReadWrite = iops
 	}
+	perf.override(b.diskPerformanceOverrides[diskStorageAccountType]).apply(&disk)
 	// If not a volume type 'zone redundant storage' restore the disk in the correct zone
 	if diskStorageAccountType != armcompute.DiskStorageAccountTypesPremiumZRS && diskStorageAccountType != armcompute.DiskStorageAccountTypesStandardSSDZRS {
-		regionParts := strings.Split(volumeAZ, "-")
-		if len(regionParts) >= 2 {
-			disk.Zones = []*string{to.Ptr(regionParts[len(regionParts)-1])}
+		if zone := diskZone(volumeAZ, deref(disk.Location)); zone != "" {
+			disk.Zones = []*string{&zone}
+		} else if volumeAZ != "" {
+			b.log.Debugf("Availability zone %s isn't a zone of region %s, the disk is restored without zone", volumeAZ, deref(disk.Location))
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), b.apiTimeout)
+	ctx, cancel := context.WithTimeout(ctx, b.apiTimeout)
 	defer cancel()
 
-	pollerResp, err := b.disks.BeginCreateOrUpdate(ctx, b.disksResourceGroup, *disk.Name, disk, nil)
+	// the volumes are restored in the resource group of the credentials, wherever the disks
+	// of the backup were
+	disks, err := b.getDisksClient(b.disksSubscription)
+	if err != nil {
+		return "", err
+	}
+	pollerResp, err := disks.BeginCreateOrUpdate(ctx, b.disksResourceGroup, *disk.Name, disk, nil)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
-	_, err = pollerResp.PollUntilDone(ctx, &azruntime.PollUntilDoneOptions{Frequency: pollingDelay})
+	_, err = pollUntilDone(ctx, pollerResp, "CreateVolumeFromSnapshot")
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
+	// the disk is in the resource group of the credentials, where SetVolumeID locates disk names
 	return diskName, nil
 }
 
-func (b *VolumeSnapshotter) GetVolumeInfo(volumeID, volumeAZ string) (string, *int64, error) {
-	res, err := b.disks.Get(context.TODO(), b.disksResourceGroup, volumeID, nil)
+func (b *VolumeSnapshotter) GetVolumeInfo(volumeID, volumeAZ string) (_ string, _ *int64, err error) {
+	ctx, end := startVolumeSnapshotterOperation("GetVolumeInfo", attribute.String("volumeID", volumeID))
+	defer end(&err)
+	diskID, err := b.parseVolumeID(volumeID)
+	if err != nil {
+		return "", nil, err
+	}
+	disks, err := b.getDisksClient(diskID.subscription)
+	if err != nil {
+		return "", nil, err
+	}
+	res, err := disks.Get(withOperation(ctx, "GetVolumeInfo"), diskID.resourceGroup, diskID.name, nil)
 	if err != nil {
 		return "", nil, errors.WithStack(err)
 	}
@@ -223,29 +389,32 @@ func (b *VolumeSnapshotter) GetVolumeInfo(volumeID, volumeAZ string) (string, *i
 		return "", nil, errors.New("disk has a nil SKU")
 	}
 
-	return string(*res.SKU.Name), nil, nil
+	// only the IOPS of Premium SSD v2 and Ultra disks are provisioned
+	return string(*res.SKU.Name), getDiskPerformance(&res.Disk).iopsReadWrite, nil
 }
 
-func (b *VolumeSnapshotter) CreateSnapshot(volumeID, volumeAZ string, tags map[string]string) (string, error) {
-	// Lookup disk info for its Location
-	diskInfo, err := b.disks.Get(context.TODO(), b.disksResourceGroup, volumeID, nil)
+func (b *VolumeSnapshotter) CreateSnapshot(volumeID, volumeAZ string, tags map[string]string) (_ string, err error) {
+	ctx, end := startVolumeSnapshotterOperation("CreateSnapshot", attribute.String("volumeID", volumeID))
+	defer end(&err)
+	ctx = withOperation(ctx, "CreateSnapshot")
+	diskID, err := b.parseVolumeID(volumeID)
 	if err != nil {
-		return "", errors.WithStack(err)
+		return "", err
 	}
-
-	fullDiskName := getComputeResourceName(b.disksSubscription, b.disksResourceGroup, disksResource, volumeID)
-	// snapshot names must be <= 80 characters long
-	var snapshotName string
-	uid, err := uuid.NewV4()
+	disks, err := b.getDisksClient(diskID.subscription)
+	if err != nil {
+		return "", err
+	}
+	// Lookup disk info for its Location
+	diskInfo, err := disks.Get(ctx, diskID.resourceGroup, diskID.name, nil)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
-	suffix := "-" + uid.String()
 
-	if len(volumeID) <= (80 - len(suffix)) {
-		snapshotName = volumeID + suffix
-	} else {
-		snapshotName = volumeID[0:80-len(suffix)] + suffix
+	fullDiskName := diskID.String()
+	snapshotName, err := newSnapshotName(diskID.name)
+	if err != nil {
+		return "", err
 	}
 
 	snap := armcompute.Snapshot{
@@ -260,19 +429,48 @@ func (b *VolumeSnapshotter) CreateSnapshot(volumeID, volumeAZ string, tags map[s
 		Tags:     getSnapshotTags(tags, b.snapsTags, diskInfo.Tags),
 		Location: diskInfo.Location,
 	}
+	// record the performance settings of the disk, to restore them with it
+	if perfTags := getDiskPerformance(&diskInfo.Disk).tags(); len(perfTags) > 0 {
+		if snap.Tags == nil {
+			snap.Tags = make(map[string]*string)
+		}
+		maps.Copy(snap.Tags, perfTags)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), b.apiTimeout)
-	defer cancel()
+	snapshotID := &snapshotIdentifier{subscription: b.snapsSubscription, resourceGroup: b.snapsResourceGroup, name: snapshotName}
+	if err := b.createSnapshot(ctx, snapshotID, snap, "CreateSnapshot"); err != nil {
+		return "", err
+	}
 
-	pollerResp, err := b.snaps.BeginCreateOrUpdate(ctx, b.snapsResourceGroup, *snap.Name, snap, nil)
-	if err != nil {
-		return "", errors.WithStack(err)
+	if b.snapsWaitForCompletion && b.snapsIncremental != nil && *b.snapsIncremental {
+		if err := b.waitForSnapshotCompletion(ctx, snapshotID.resourceGroup, snapshotID.name); err != nil {
+			return "", err
+		}
+	}
+
+	if err := b.copySnapshot(ctx, snapshotID, diskID.name, *diskInfo.Location, snap.Tags); err != nil {
+		// Velero doesn't know about the snapshot and its copies when the backup of the volume fails
+		if deleteErr := b.deleteSnapshots(ctx, snapshotID); deleteErr != nil {
+			b.log.WithError(deleteErr).Warnf("Error deleting snapshot %s after its copy failed", snapshotID.fullName())
+		}
+		return "", err
 	}
-	_, err = pollerResp.PollUntilDone(ctx, &azruntime.PollUntilDoneOptions{Frequency: pollingDelay})
+	return snapshotID.fullName(), nil
+}
+
+// newSnapshotName returns a unique name for a snapshot of the disk
+func newSnapshotName(diskName string) (string, error) {
+	// snapshot names must be <= 80 characters long
+	uid, err := uuid.NewV4()
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
-	return getComputeResourceName(b.snapsSubscription, b.snapsResourceGroup, snapshotsResource, snapshotName), nil
+	suffix := "-" + uid.String()
+
+	if len(diskName) <= (80 - len(suffix)) {
+		return diskName + suffix, nil
+	}
+	return diskName[0:80-len(suffix)] + suffix, nil
 }
 
 func getSnapshotTags(veleroTags, snapsTags map[string]string, diskTags map[string]*string) map[string]*string {
@@ -308,21 +506,42 @@ func stringPtr(s string) *string {
 	return &s
 }
 
-func (b *VolumeSnapshotter) DeleteSnapshot(snapshotID string) error {
+func (b *VolumeSnapshotter) DeleteSnapshot(snapshotID string) (err error) {
+	ctx, end := startVolumeSnapshotterOperation("DeleteSnapshot", attribute.String("snapshotID", snapshotID))
+	defer end(&err)
 	snapshotInfo, err := parseFullSnapshotName(snapshotID)
 	if err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), b.apiTimeout)
+	return b.deleteSnapshots(withOperation(ctx, "DeleteSnapshot"), snapshotInfo)
+}
+
+// deleteSnapshots deletes the copies of the snapshot, then the snapshot. Every deletion is
+// attempted, even when others fail, and their errors are returned together.
+func (b *VolumeSnapshotter) deleteSnapshots(ctx context.Context, snapshotInfo *snapshotIdentifier) error {
+	var errs []error
+	for _, c := range snapshotInfo.copies {
+		if err := b.deleteSnapshot(ctx, c.id); err != nil {
+			errs = append(errs, errors.Wrapf(err, "error deleting the copy of the snapshot in region %s", c.region))
+		}
+	}
+	if err := b.deleteSnapshot(ctx, snapshotInfo); err != nil {
+		errs = append(errs, err)
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+func (b *VolumeSnapshotter) deleteSnapshot(ctx context.Context, snapshotInfo *snapshotIdentifier) error {
+	ctx, cancel := context.WithTimeout(ctx, b.apiTimeout)
 	defer cancel()
 
 	// we don't want to return an error if the snapshot doesn't exist, and
 	// the Delete(..) call does not return a clear error if that's the case,
 	// so first try to get it and return early if we get a 404.
-	_, err = b.snaps.Get(ctx, snapshotInfo.resourceGroup, snapshotInfo.name, nil)
+	_, err := b.snaps.Get(ctx, snapshotInfo.resourceGroup, snapshotInfo.name, nil)
 	if azureErr, ok := err.(*azcore.ResponseError); ok && azureErr.StatusCode == http.StatusNotFound {
-		b.log.WithField("snapshotID", snapshotID).Debug("Snapshot not found")
+		b.log.WithField("snapshotID", snapshotInfo.String()).Debug("Snapshot not found")
 		return nil
 	}
 
@@ -330,7 +549,7 @@ func (b *VolumeSnapshotter) DeleteSnapshot(snapshotID string) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	_, err = pollerResp.PollUntilDone(ctx, &azruntime.PollUntilDoneOptions{Frequency: pollingDelay})
+	_, err = pollUntilDone(ctx, pollerResp, "DeleteSnapshot")
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -338,20 +557,124 @@ func (b *VolumeSnapshotter) DeleteSnapshot(snapshotID string) error {
 	return nil
 }
 
+// pollUntilDone polls the long-running operation every pollingDelay until it completes
+func pollUntilDone[T any](ctx context.Context, poller *azruntime.Poller[T], operation string) (_ T, err error) {
+	ctx, span := startSpan(ctx, "PollUntilDone")
+	polls := 0
+	defer func() {
+		volumeSnapshotterPollIterations.WithLabelValues(operation).Observe(float64(polls))
+		span.SetAttributes(attribute.Int("polls", polls))
+		endSpan(span, err)
+	}()
+
+	for !poller.Done() {
+		polls++
+		if _, err := poller.Poll(ctx); err != nil {
+			var zero T
+			return zero, err
+		}
+		if poller.Done() {
+			break
+		}
+
+		select {
+		case <-time.After(pollingDelay):
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+	return poller.Result(ctx)
+}
+
+// diskZone returns the zone of a disk restored in the location from the availability zone of
+// its volume, e.g. "1" for "westeurope-1". Zones are specific to their region, a volume's zone
+// doesn't apply to a disk restored in another region, nor does the fault domain of a volume of
+// a cluster without zones, e.g. "0".
+func diskZone(volumeAZ, location string) string {
+	i := strings.LastIndex(volumeAZ, "-")
+	if i < 0 || normalizeRegion(volumeAZ[:i]) != normalizeRegion(location) {
+		return ""
+	}
+	if _, err := strconv.Atoi(volumeAZ[i+1:]); err != nil {
+		return ""
+	}
+	return volumeAZ[i+1:]
+}
+
+// getDisksClient returns the client of the disks of the subscription
+func (b *VolumeSnapshotter) getDisksClient(subscription string) (*armcompute.DisksClient, error) {
+	b.disksLock.Lock()
+	defer b.disksLock.Unlock()
+
+	// subscription IDs are case-insensitive
+	key := strings.ToLower(subscription)
+	if client, ok := b.disks[key]; ok {
+		return client, nil
+	}
+	client, err := armcompute.NewDisksClient(subscription, b.credential, b.clientOptions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating disk client for subscription %s", subscription)
+	}
+	if b.disks == nil {
+		b.disks = map[string]*armcompute.DisksClient{}
+	}
+	b.disks[key] = client
+	return client, nil
+}
+
+// parseVolumeID returns the disk of a volume ID, which is the full ARM ID of the disk. Volume
+// IDs of backups taken by previous versions of the plugin are disk names, the disks are then
+// in the resource group of the credentials.
+func (b *VolumeSnapshotter) parseVolumeID(volumeID string) (*diskIdentifier, error) {
+	volumeID = strings.TrimSpace(volumeID)
+	if !strings.Contains(volumeID, "/") {
+		return &diskIdentifier{subscription: b.disksSubscription, resourceGroup: b.disksResourceGroup, name: volumeID}, nil
+	}
+
+	id, err := arm.ParseResourceID(volumeID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse volume ID %q", volumeID)
+	}
+	// resource types are case-insensitive, and CSI volume handles are sometimes lowercase
+	if !strings.EqualFold(id.ResourceType.String(), diskResourceType) {
+		return nil, errors.Errorf("volume ID %q isn't the ID of a managed disk", volumeID)
+	}
+	return &diskIdentifier{subscription: id.SubscriptionID, resourceGroup: id.ResourceGroupName, name: id.Name}, nil
+}
+
 func getComputeResourceName(subscription, resourceGroup, resource, name string) string {
 	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/%s/%s", subscription, resourceGroup, resource, name)
 }
 
-var (
-	snapshotURIRegexp = regexp.MustCompile(
-		`^\/subscriptions\/(?P<subscription>.*)\/resourceGroups\/(?P<resourceGroup>.*)\/providers\/Microsoft.Compute\/snapshots\/(?P<snapshotName>.*)$`)
-	diskURIRegexp = regexp.MustCompile(`\/Microsoft.Compute\/disks\/.*$`)
-)
+var snapshotURIRegexp = regexp.MustCompile(
+	`^\/subscriptions\/(?P<subscription>.*)\/resourceGroups\/(?P<resourceGroup>.*)\/providers\/Microsoft.Compute\/snapshots\/(?P<snapshotName>.*)$`)
 
-// parseFullSnapshotName takes a fully-qualified snapshot name and returns
-// a snapshot identifier or an error if the snapshot name does not match the
-// regexp.
+// parseFullSnapshotName takes a fully-qualified snapshot name, optionally followed by the
+// regions and the IDs of the copies of the snapshot, and returns a snapshot identifier or an
+// error if the snapshot name does not match the regexp.
 func parseFullSnapshotName(name string) (*snapshotIdentifier, error) {
+	parts := strings.Split(name, snapshotCopySeparator)
+	snapshotID, err := parseSnapshotID(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, part := range parts[1:] {
+		region, id, ok := strings.Cut(part, "=")
+		if !ok || region == "" {
+			return nil, errors.Errorf("snapshot copy %q could not be parsed", part)
+		}
+		copyID, err := parseSnapshotID(id)
+		if err != nil {
+			return nil, err
+		}
+		snapshotID.copies = append(snapshotID.copies, snapshotCopy{region: region, id: copyID})
+	}
+	return snapshotID, nil
+}
+
+func parseSnapshotID(name string) (*snapshotIdentifier, error) {
 	submatches := snapshotURIRegexp.FindStringSubmatch(name)
 	if len(submatches) != len(snapshotURIRegexp.SubexpNames()) {
 		return nil, errors.New("snapshot URI could not be parsed")
@@ -385,7 +708,14 @@ func (b *VolumeSnapshotter) GetVolumeID(unstructuredPV runtime.Unstructured) (st
 
 	if pv.Spec.CSI != nil {
 		if pv.Spec.CSI.Driver == diskCSIDriver {
-			return strings.TrimPrefix(diskURIRegexp.FindString(pv.Spec.CSI.VolumeHandle), "/Microsoft.Compute/disks/"), nil
+			// the volume isn't backed up when its handle isn't the ID of a disk
+			if strings.Contains(pv.Spec.CSI.VolumeHandle, "/") {
+				if diskID, err := b.parseVolumeID(pv.Spec.CSI.VolumeHandle); err == nil {
+					return diskID.String(), nil
+				}
+			}
+			b.log.Infof("Unable to find a disk in volume handle %q", pv.Spec.CSI.VolumeHandle)
+			return "", nil
 		}
 		b.log.Infof("Unable to handle CSI driver: %s", pv.Spec.CSI.Driver)
 	}
@@ -398,6 +728,13 @@ func (b *VolumeSnapshotter) GetVolumeID(unstructuredPV runtime.Unstructured) (st
 		return "", errors.New("spec.azureDisk.diskName not found")
 	}
 
+	// the URI of a disk is its ARM ID, which locates disks outside of the resource group of
+	// the credentials
+	if uri := pv.Spec.AzureDisk.DataDiskURI; strings.Contains(uri, "/") {
+		if diskID, err := b.parseVolumeID(uri); err == nil {
+			return diskID.String(), nil
+		}
+	}
 	return pv.Spec.AzureDisk.DiskName, nil
 }
 
@@ -407,16 +744,21 @@ func (b *VolumeSnapshotter) SetVolumeID(unstructuredPV runtime.Unstructured, vol
 		return nil, errors.WithStack(err)
 	}
 
+	diskID, err := b.parseVolumeID(volumeID)
+	if err != nil {
+		return nil, err
+	}
+
 	if pv.Spec.CSI != nil {
 		if pv.Spec.CSI.Driver == diskCSIDriver {
-			pv.Spec.CSI.VolumeHandle = getComputeResourceName(b.disksSubscription, b.disksResourceGroup, disksResource, volumeID)
+			pv.Spec.CSI.VolumeHandle = diskID.String()
 		} else {
 			return nil, fmt.Errorf("unable to handle CSI driver: %s", pv.Spec.CSI.Driver)
 		}
 
 	} else if pv.Spec.AzureDisk != nil {
-		pv.Spec.AzureDisk.DiskName = volumeID
-		pv.Spec.AzureDisk.DataDiskURI = getComputeResourceName(b.disksSubscription, b.disksResourceGroup, disksResource, volumeID)
+		pv.Spec.AzureDisk.DiskName = diskID.name
+		pv.Spec.AzureDisk.DataDiskURI = diskID.String()
 	} else {
 		return nil, errors.New("spec.csi and spec.azureDisk not found")
 	}
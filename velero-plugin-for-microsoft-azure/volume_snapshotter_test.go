@@ -17,8 +17,17 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v4"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -56,6 +65,12 @@ func TestGetVolumeID(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "foo", volumeID)
 
+	// valid, with the URI of a disk in another resource group
+	azure["diskURI"] = "/subscriptions/sub-2/resourceGroups/rg-2/providers/Microsoft.Compute/disks/foo"
+	volumeID, err = b.GetVolumeID(pv)
+	assert.NoError(t, err)
+	assert.Equal(t, "/subscriptions/sub-2/resourceGroups/rg-2/providers/Microsoft.Compute/disks/foo", volumeID)
+
 	// CSI driver: unknown driver name
 	csi := map[string]interface{}{
 		"driver":       "unknown.csi.azure.com",
@@ -64,7 +79,7 @@ func TestGetVolumeID(t *testing.T) {
 	pv.Object["spec"].(map[string]interface{})["csi"] = csi
 	volumeID, err = b.GetVolumeID(pv)
 	assert.NoError(t, err)
-	assert.Equal(t, "foo", volumeID)
+	assert.Equal(t, "/subscriptions/sub-2/resourceGroups/rg-2/providers/Microsoft.Compute/disks/foo", volumeID)
 
 	// CSI driver: pass
 	csi = map[string]interface{}{
@@ -74,7 +89,21 @@ func TestGetVolumeID(t *testing.T) {
 	pv.Object["spec"].(map[string]interface{})["csi"] = csi
 	volumeID, err = b.GetVolumeID(pv)
 	assert.NoError(t, err)
-	assert.Equal(t, "bar", volumeID)
+	assert.Equal(t, "/subscriptions/subscription-id/resourceGroups/resource-group-name/providers/Microsoft.Compute/disks/bar", volumeID)
+
+	// CSI driver: lowercase volume handle
+	csi["volumeHandle"] = "/subscriptions/subscription-id/resourcegroups/mc_rg_cluster_westeurope/providers/microsoft.compute/disks/pvc-1"
+	volumeID, err = b.GetVolumeID(pv)
+	assert.NoError(t, err)
+	assert.Equal(t, "/subscriptions/subscription-id/resourceGroups/mc_rg_cluster_westeurope/providers/Microsoft.Compute/disks/pvc-1", volumeID)
+
+	// CSI driver: not a disk -> skipped
+	for _, handle := range []string{"/subscriptions/subscription-id/resourceGroups/resource-group-name/providers/Microsoft.Compute/snapshots/bar", "bar", "/not/an/arm/id"} {
+		csi["volumeHandle"] = handle
+		volumeID, err = b.GetVolumeID(pv)
+		assert.NoError(t, err, handle)
+		assert.Equal(t, "", volumeID, handle)
+	}
 }
 
 func TestSetVolumeID(t *testing.T) {
@@ -138,6 +167,52 @@ func TestSetVolumeID(t *testing.T) {
 	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(updatedPV.UnstructuredContent(), res))
 	require.NotNil(t, res.Spec.CSI)
 	assert.Equal(t, "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/updated", res.Spec.CSI.VolumeHandle)
+
+	// CSI driver: full disk ID
+	updatedPV, err = b.SetVolumeID(pv, "/subscriptions/sub-2/resourceGroups/rg-2/providers/Microsoft.Compute/disks/restored")
+	require.NoError(t, err)
+
+	res = new(v1.PersistentVolume)
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(updatedPV.UnstructuredContent(), res))
+	assert.Equal(t, "/subscriptions/sub-2/resourceGroups/rg-2/providers/Microsoft.Compute/disks/restored", res.Spec.CSI.VolumeHandle)
+}
+
+func TestParseVolumeID(t *testing.T) {
+	b := &VolumeSnapshotter{
+		disksResourceGroup: "rg",
+		disksSubscription:  "sub",
+	}
+
+	// disk name of a previous version of the plugin
+	diskID, err := b.parseVolumeID("disk-1")
+	require.NoError(t, err)
+	assert.Equal(t, &diskIdentifier{subscription: "sub", resourceGroup: "rg", name: "disk-1"}, diskID)
+
+	// full disk ID
+	diskID, err = b.parseVolumeID("/subscriptions/sub-2/resourceGroups/rg-2/providers/Microsoft.Compute/disks/disk-2")
+	require.NoError(t, err)
+	assert.Equal(t, &diskIdentifier{subscription: "sub-2", resourceGroup: "rg-2", name: "disk-2"}, diskID)
+	assert.Equal(t, "/subscriptions/sub-2/resourceGroups/rg-2/providers/Microsoft.Compute/disks/disk-2", diskID.String())
+
+	// not a disk
+	_, err = b.parseVolumeID("/subscriptions/sub-2/resourceGroups/rg-2/providers/Microsoft.Network/disks/disk-2")
+	assert.Error(t, err)
+	_, err = b.parseVolumeID("/foo/bar")
+	assert.Error(t, err)
+}
+
+func TestGetDisksClient(t *testing.T) {
+	b := &VolumeSnapshotter{credential: &fakeCredential{}}
+
+	client, err := b.getDisksClient("sub-1")
+	require.NoError(t, err)
+	same, err := b.getDisksClient("SUB-1")
+	require.NoError(t, err)
+	assert.Same(t, client, same)
+
+	other, err := b.getDisksClient("sub-2")
+	require.NoError(t, err)
+	assert.NotSame(t, client, other)
 }
 
 func TestParseFullSnapshotName(t *testing.T) {
@@ -162,6 +237,26 @@ func TestGetComputeResourceName(t *testing.T) {
 	assert.Equal(t, "/subscriptions/sub-1/resourceGroups/rg-1/providers/Microsoft.Compute/snapshots/snap-1", getComputeResourceName("sub-1", "rg-1", snapshotsResource, "snap-1"))
 }
 
+func TestDiskZone(t *testing.T) {
+	tests := []struct {
+		volumeAZ string
+		location string
+		expected string
+	}{
+		{volumeAZ: "westeurope-1", location: "westeurope", expected: "1"},
+		{volumeAZ: "westeurope-3", location: "West Europe", expected: "3"},
+		{volumeAZ: "westeurope-1", location: "northeurope", expected: ""},
+		{volumeAZ: "0", location: "westeurope", expected: ""},
+		{volumeAZ: "westeurope-0-1", location: "westeurope", expected: ""},
+		{volumeAZ: "westeurope-", location: "westeurope", expected: ""},
+		{volumeAZ: "", location: "westeurope", expected: ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, diskZone(test.volumeAZ, test.location), test.volumeAZ)
+	}
+}
+
 func TestGetSnapshotTags(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -266,3 +361,40 @@ func TestGetSnapshotTags(t *testing.T) {
 		})
 	}
 }
+
+type fakeCredential struct{}
+
+func (c *fakeCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// newFakeARMSnapshotter returns a volume snapshotter whose requests to ARM are answered by the
+// handler, and whose long-running operations are polled without delay
+func newFakeARMSnapshotter(t *testing.T, handler http.HandlerFunc) *VolumeSnapshotter {
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+	delay := pollingDelay
+	pollingDelay = time.Millisecond
+	t.Cleanup(func() { pollingDelay = delay })
+
+	b := &VolumeSnapshotter{
+		log:                logrus.New(),
+		credential:         &fakeCredential{},
+		disksSubscription:  "sub",
+		disksResourceGroup: "rg",
+		snapsSubscription:  "sub",
+		snapsResourceGroup: "rg",
+		apiTimeout:         time.Minute,
+		clientOptions: &arm.ClientOptions{ClientOptions: policy.ClientOptions{
+			Cloud: cloud.Configuration{Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+				cloud.ResourceManager: {Endpoint: server.URL, Audience: server.URL},
+			}},
+			Transport: server.Client(),
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		}},
+	}
+	var err error
+	b.snaps, err = armcompute.NewSnapshotsClient(b.snapsSubscription, b.credential, b.clientOptions)
+	require.NoError(t, err)
+	return b
+}